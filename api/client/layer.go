@@ -0,0 +1,69 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types"
+	flag "github.com/docker/docker/pkg/mflag"
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/docker/docker/pkg/units"
+)
+
+// CmdLayerLs lists the layers of the storage driver.
+//
+// Usage: docker layer ls [OPTIONS]
+func (cli *DockerCli) CmdLayerLs(args ...string) error {
+	cmd := cli.Subcmd("layer ls", nil, "List the layers of the storage driver", true)
+	quiet := cmd.Bool([]string{"q", "-quiet"}, false, "Only show numeric IDs")
+	noTrunc := cmd.Bool([]string{"#notrunc", "-no-trunc"}, false, "Don't truncate output")
+	cmd.Require(flag.Exact, 0)
+	cmd.ParseFlags(args, true)
+
+	rdr, _, _, err := cli.call("GET", "/layers", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	defer rdr.Close()
+
+	layers := []types.Layer{}
+	if err := json.NewDecoder(rdr).Decode(&layers); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	if !*quiet {
+		fmt.Fprintln(w, "LAYER ID\tLOCATION\tSIZE\tPARENTS\tMOUNTS\tSHARED BY")
+	}
+
+	for _, l := range layers {
+		id := l.ID
+		if !*noTrunc {
+			id = stringid.TruncateID(id)
+		}
+		if *quiet {
+			fmt.Fprintln(w, id)
+			continue
+		}
+		size := "N/A"
+		if l.Size >= 0 {
+			size = units.HumanSize(float64(l.Size))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\n", id, l.Location, size, l.Parents, l.Active, l.SharedBy)
+	}
+	w.Flush()
+	return nil
+}
+
+// CmdLayer is reached for the commands of docker layer that do not exist.
+//
+// Usage: docker layer ls
+func (cli *DockerCli) CmdLayer(args ...string) error {
+	cmd := cli.Subcmd("layer", []string{"ls"}, "Manage the layers of the storage driver", true)
+	cmd.Require(flag.Min, 1)
+	cmd.ParseFlags(args, true)
+
+	return fmt.Errorf("docker: '%s' is not a docker layer command.\nSee 'docker layer --help'.", cmd.Arg(0))
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/docker/docker/api/types"
+	flag "github.com/docker/docker/pkg/mflag"
+)
+
+// CmdStorageDump writes a tar archive describing the state of the storage
+// driver, for attaching to bug reports.
+//
+// The tar archive is written to STDOUT by default, or written to a file.
+//
+// Usage: docker storage dump [OPTIONS]
+func (cli *DockerCli) CmdStorageDump(args ...string) error {
+	cmd := cli.Subcmd("storage dump", nil, "Write the state of the storage driver to a tar archive", true)
+	outfile := cmd.String([]string{"o", "-output"}, "", "Write to a file, instead of STDOUT")
+	cmd.Require(flag.Exact, 0)
+	cmd.ParseFlags(args, true)
+
+	var (
+		output io.Writer = cli.out
+		err    error
+	)
+	if *outfile != "" {
+		output, err = os.Create(*outfile)
+		if err != nil {
+			return err
+		}
+	} else if cli.isTerminalOut {
+		return errors.New("Cowardly refusing to save to a terminal. Use the -o flag or redirect.")
+	}
+
+	sopts := &streamOpts{
+		rawTerminal: true,
+		out:         output,
+	}
+	_, err = cli.stream("GET", "/storage/dump", sopts)
+	return err
+}
+
+// CmdStorageSelftest checks that the host provides what the storage driver
+// relies on. It fails if any of the checks failed.
+//
+// Usage: docker storage selftest
+func (cli *DockerCli) CmdStorageSelftest(args ...string) error {
+	cmd := cli.Subcmd("storage selftest", nil, "Check that the host provides what the storage driver relies on", true)
+	cmd.Require(flag.Exact, 0)
+	cmd.ParseFlags(args, true)
+
+	rdr, _, _, err := cli.call("POST", "/storage/selftest", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	defer rdr.Close()
+
+	results := []types.SelfTestResult{}
+	if err := json.NewDecoder(rdr).Decode(&results); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(cli.out, 20, 1, 3, ' ', 0)
+	fmt.Fprintln(w, "CHECK\tRESULT\tDETAIL")
+	failed := false
+	for _, r := range results {
+		result := "passed"
+		switch {
+		case r.Skipped:
+			result = "skipped"
+		case !r.Passed:
+			result = "failed"
+			failed = true
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", r.Name, result, r.Detail)
+	}
+	w.Flush()
+	if failed {
+		return StatusError{StatusCode: 1}
+	}
+	return nil
+}
+
+// CmdStorage is reached for the commands of docker storage that do not
+// exist.
+//
+// Usage: docker storage dump|selftest
+func (cli *DockerCli) CmdStorage(args ...string) error {
+	cmd := cli.Subcmd("storage", []string{"dump|selftest"}, "Inspect the storage driver", true)
+	cmd.Require(flag.Min, 1)
+	cmd.ParseFlags(args, true)
+
+	return fmt.Errorf("docker: '%s' is not a docker storage command.\nSee 'docker storage --help'.", cmd.Arg(0))
+}
@@ -511,6 +511,75 @@ func (s *Server) getImagesHistory(version version.Version, w http.ResponseWriter
 	return writeJSON(w, http.StatusOK, history)
 }
 
+func (s *Server) getLayers(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	layers, err := s.daemon.Layers()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, layers)
+}
+
+func (s *Server) getLayersPlacement(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	placement, err := s.daemon.LayerPlacement(vars["id"])
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, placement)
+}
+
+func (s *Server) postLayersBind(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if err := s.daemon.LayerBindReadOnly(vars["id"], r.Form.Get("target")); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) postLayersUnbind(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if err := parseForm(r); err != nil {
+		return err
+	}
+	if err := s.daemon.LayerUnbindReadOnly(r.Form.Get("target")); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) getStorageDump(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	w.Header().Set("Content-Type", "application/x-tar")
+
+	output := ioutils.NewWriteFlusher(w)
+	if err := s.daemon.StorageDump(output); err != nil {
+		if !output.Flushed() {
+			return err
+		}
+		sf := streamformatter.NewJSONStreamFormatter()
+		output.Write(sf.FormatError(err))
+	}
+	return nil
+}
+
+func (s *Server) postStorageSelfTest(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	results, err := s.daemon.StorageSelfTest()
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, results)
+}
+
 func (s *Server) getContainersChanges(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if vars == nil {
 		return fmt.Errorf("Missing parameter")
@@ -667,6 +736,28 @@ func (s *Server) postImagesTag(version version.Version, w http.ResponseWriter, r
 	return nil
 }
 
+func (s *Server) postImagesPin(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := s.daemon.ImagePin(vars["name"], true); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) postImagesUnpin(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
+	if vars == nil {
+		return fmt.Errorf("Missing parameter")
+	}
+	if err := s.daemon.ImagePin(vars["name"], false); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
 func (s *Server) postCommit(version version.Version, w http.ResponseWriter, r *http.Request, vars map[string]string) error {
 	if err := parseForm(r); err != nil {
 		return err
@@ -1547,6 +1638,9 @@ func createRouter(s *Server) *mux.Router {
 			"/images/{name:.*}/get":           s.getImagesGet,
 			"/images/{name:.*}/history":       s.getImagesHistory,
 			"/images/{name:.*}/json":          s.getImagesByName,
+			"/layers":                         s.getLayers,
+			"/layers/{id:.*}/placement":       s.getLayersPlacement,
+			"/storage/dump":                   s.getStorageDump,
 			"/containers/ps":                  s.getContainersJSON,
 			"/containers/json":                s.getContainersJSON,
 			"/containers/{name:.*}/export":    s.getContainersExport,
@@ -1566,6 +1660,11 @@ func createRouter(s *Server) *mux.Router {
 			"/images/load":                  s.postImagesLoad,
 			"/images/{name:.*}/push":        s.postImagesPush,
 			"/images/{name:.*}/tag":         s.postImagesTag,
+			"/images/{name:.*}/pin":         s.postImagesPin,
+			"/images/{name:.*}/unpin":       s.postImagesUnpin,
+			"/layers/{id:.*}/bind":          s.postLayersBind,
+			"/layers/unbind":                s.postLayersUnbind,
+			"/storage/selftest":             s.postStorageSelfTest,
 			"/containers/create":            s.postContainersCreate,
 			"/containers/{name:.*}/kill":    s.postContainersKill,
 			"/containers/{name:.*}/pause":   s.postContainersPause,
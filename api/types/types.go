@@ -57,6 +57,36 @@ type ImageHistory struct {
 	Comment   string
 }
 
+// GET "/layers"
+type Layer struct {
+	ID string `json:"Id"`
+	// Location is local-image, local-container or local, for the layers
+	// that are neither.
+	Location string
+	Size     int64
+	Parents  int
+	Active   int
+	SharedBy int
+}
+
+// GET "/layers/{id:.*}/placement"
+type LayerPlacement struct {
+	Path string
+	// Device is the device holding the layer, as major:minor.
+	Device     string
+	Mountpoint string
+	Fstype     string
+	Source     string
+}
+
+// POST "/storage/selftest"
+type SelfTestResult struct {
+	Name    string
+	Passed  bool
+	Skipped bool   `json:",omitempty"`
+	Detail  string `json:",omitempty"`
+}
+
 // DELETE "/images/{name:.*}"
 type ImageDelete struct {
 	Untagged string `json:",omitempty"`
@@ -152,6 +182,7 @@ type Info struct {
 	Images             int
 	Driver             string
 	DriverStatus       [][2]string
+	DriverDetails      interface{} `json:",omitempty"`
 	MemoryLimit        bool
 	SwapLimit          bool
 	CpuCfsPeriod       bool
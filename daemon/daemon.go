@@ -103,6 +103,7 @@ type Daemon struct {
 	EventsService    *events.Events
 	netController    libnetwork.NetworkController
 	root             string
+	importLock       sync.Mutex // Serializes the registration of imported layers
 }
 
 // Get looks for a container using the provided information, which could be
@@ -736,6 +737,11 @@ func NewDaemon(config *Config, registryService *registry.Service) (daemon *Daemo
 		return nil, err
 	}
 
+	if importer, ok := d.driver.(graphdriver.LayerImporter); ok {
+		importer.NotifyImported(d.registerImported)
+		d.registerImported()
+	}
+
 	return d, nil
 }
 
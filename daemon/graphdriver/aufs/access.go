@@ -0,0 +1,130 @@
+// +build linux
+
+package aufs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The last time each layer was used by a mount is kept in memory and
+// written to the access file in batches, so Get never waits for a write.
+// Like relatime, a layer used again within accessGranularity of its
+// recorded time is not rewritten at all.
+
+const (
+	accessGranularity   = time.Hour
+	accessFlushInterval = time.Minute
+)
+
+func (a *Driver) accessPath() string {
+	return path.Join(a.rootPath(), "access")
+}
+
+// loadAccessTimes reads the access file written by flushAccessTimes.
+func (a *Driver) loadAccessTimes() error {
+	b, err := ioutil.ReadFile(a.accessPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var stamps map[string]int64
+	if err := json.Unmarshal(b, &stamps); err != nil {
+		return fmt.Errorf("aufs: corrupted access file %s: %v", a.accessPath(), err)
+	}
+	a.Lock()
+	defer a.Unlock()
+	for id, sec := range stamps {
+		a.accessTimes[id] = time.Unix(sec, 0)
+	}
+	return nil
+}
+
+// touch records that the given layers were used at now.
+// Must be called with the driver lock held.
+func (a *Driver) touch(ids []string, now time.Time) {
+	for _, id := range ids {
+		if last, ok := a.accessTimes[id]; ok && now.Sub(last) < accessGranularity {
+			continue
+		}
+		a.accessTimes[id] = now
+		a.accessDirty = true
+	}
+}
+
+// LastAccess returns the last time the layer with the given id was used
+// by a mount, with hour granularity, and false if it never was.
+func (a *Driver) LastAccess(id string) (time.Time, bool) {
+	a.Lock()
+	defer a.Unlock()
+	t, ok := a.accessTimes[id]
+	return t, ok
+}
+
+// startAccessFlusher writes the access times at every interval until
+// Cleanup is called.
+func (a *Driver) startAccessFlusher() {
+	a.stopAccess = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(accessFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := a.flushAccessTimes(); err != nil {
+					logrus.Errorf("aufs: writing access times: %v", err)
+				}
+			case <-a.stopAccess:
+				return
+			}
+		}
+	}()
+}
+
+// flushAccessTimes writes the access times if they changed since the
+// last flush.
+func (a *Driver) flushAccessTimes() error {
+	a.accessLock.Lock()
+	defer a.accessLock.Unlock()
+
+	a.Lock()
+	if !a.accessDirty {
+		a.Unlock()
+		return nil
+	}
+	stamps := make(map[string]int64, len(a.accessTimes))
+	for id, t := range a.accessTimes {
+		stamps[id] = t.Unix()
+	}
+	a.accessDirty = false
+	a.Unlock()
+
+	err := writeAccessTimes(a.accessPath(), stamps)
+	if err != nil {
+		// Try again at the next flush
+		a.Lock()
+		a.accessDirty = true
+		a.Unlock()
+	}
+	return err
+}
+
+func writeAccessTimes(p string, stamps map[string]int64) error {
+	b, err := json.Marshal(stamps)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
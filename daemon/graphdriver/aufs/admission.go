@@ -0,0 +1,208 @@
+// +build linux
+
+package aufs
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultHookTimeout = 10 * time.Second
+
+// AdmissionRequest describes a storage operation submitted to the
+// admission hook before it is carried out.
+type AdmissionRequest struct {
+	// Op is either "create" or "mount".
+	Op         string
+	ID         string
+	Parent     string `json:",omitempty"`
+	MountLabel string `json:",omitempty"`
+	// Annotations are those of the layer, for a mount.
+	Annotations map[string]string `json:",omitempty"`
+	// Chain is the chain of layers the layer is built on, closest first.
+	Chain []AdmissionLayer `json:",omitempty"`
+}
+
+// AdmissionLayer describes a layer of the chain of an AdmissionRequest.
+type AdmissionLayer struct {
+	ID          string
+	Annotations map[string]string `json:",omitempty"`
+}
+
+// AdmissionResponse is what the hook may print on its standard output to
+// alter an admitted operation. An empty output admits it unchanged.
+type AdmissionResponse struct {
+	// MountLabel, if set, replaces the mount label of a mount operation.
+	MountLabel string `json:",omitempty"`
+}
+
+// ErrAdmissionDenied is returned when the admission hook vetoes an
+// operation.
+type ErrAdmissionDenied struct {
+	Op     string
+	ID     string
+	Reason string
+}
+
+func (e ErrAdmissionDenied) Error() string {
+	return fmt.Sprintf("aufs: %s of %s denied by admission hook: %s", e.Op, e.ID, e.Reason)
+}
+
+// admissionHook is consulted for each admission request. A hook whose
+// path is an http or https URL is sent the request as JSON in a POST, and
+// a status other than 200 denies the operation with the body as reason.
+// Otherwise the hook is an external program that is sent the request as
+// JSON on its standard input, and a non-zero exit status denies the
+// operation with the standard error as reason. Either way, the response
+// is an optional AdmissionResponse.
+type admissionHook struct {
+	path     string
+	timeout  time.Duration
+	failOpen bool
+}
+
+// admit submits req to the admission hook, if any is configured, and
+// applies the changes it asks for. Failures to run the hook itself deny
+// the operation unless the hook is configured to fail open.
+func (a *Driver) admit(req *AdmissionRequest) error {
+	h := a.options.hook
+	if h == nil {
+		return nil
+	}
+	if err := a.describe(req); err != nil {
+		return fmt.Errorf("aufs: describing the %s of %s to the admission hook: %v", req.Op, req.ID, err)
+	}
+	var (
+		resp *AdmissionResponse
+		err  error
+	)
+	if h.isURL() {
+		resp, err = h.post(req)
+	} else {
+		resp, err = h.run(req)
+	}
+	if err != nil {
+		if _, denied := err.(ErrAdmissionDenied); denied || !h.failOpen {
+			return err
+		}
+		logrus.Warnf("aufs: admitting %s of %s despite hook failure: %v", req.Op, req.ID, err)
+		return nil
+	}
+	if resp.MountLabel != "" {
+		req.MountLabel = resp.MountLabel
+	}
+	return nil
+}
+
+// describe fills in the annotations and the chain of req, so the hook can
+// decide on what the layer is built on.
+func (a *Driver) describe(req *AdmissionRequest) error {
+	var (
+		chain []string
+		err   error
+	)
+	switch req.Op {
+	case "create":
+		if req.Parent != "" {
+			if chain, err = getParentIds(a.rootPath(), req.Parent); err != nil {
+				return err
+			}
+			chain = append([]string{req.Parent}, chain...)
+		}
+	case "mount":
+		if chain, err = getParentIds(a.rootPath(), req.ID); err != nil {
+			return err
+		}
+		if req.Annotations, err = readAnnotations(a.annotationsPath(req.ID)); err != nil {
+			return err
+		}
+	}
+	req.Chain = make([]AdmissionLayer, len(chain))
+	for i, id := range chain {
+		req.Chain[i].ID = id
+		if req.Chain[i].Annotations, err = readAnnotations(a.annotationsPath(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *admissionHook) isURL() bool {
+	return strings.HasPrefix(h.path, "http://") || strings.HasPrefix(h.path, "https://")
+}
+
+func (h *admissionHook) post(req *AdmissionRequest) (*AdmissionResponse, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	client := &http.Client{Timeout: h.timeout}
+	res, err := client.Post(h.path, "application/json", bytes.NewReader(in))
+	if err != nil {
+		return nil, fmt.Errorf("aufs: calling admission hook %s: %v", h.path, err)
+	}
+	defer res.Body.Close()
+	out, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("aufs: calling admission hook %s: %v", h.path, err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, ErrAdmissionDenied{Op: req.Op, ID: req.ID, Reason: strings.TrimSpace(string(out))}
+	}
+	return parseAdmissionResponse(out)
+}
+
+func (h *admissionHook) run(req *AdmissionRequest) (*AdmissionResponse, error) {
+	in, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(h.path)
+	cmd.Stdin = bytes.NewReader(in)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// Run the hook in its own process group so that a timeout takes down
+	// whatever it spawned too
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("aufs: running admission hook %s: %v", h.path, err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err = <-done:
+	case <-time.After(h.timeout):
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+		return nil, fmt.Errorf("aufs: admission hook %s timed out after %s", h.path, h.timeout)
+	}
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return nil, ErrAdmissionDenied{Op: req.Op, ID: req.ID, Reason: strings.TrimSpace(stderr.String())}
+		}
+		return nil, fmt.Errorf("aufs: running admission hook %s: %v", h.path, err)
+	}
+
+	return parseAdmissionResponse(stdout.Bytes())
+}
+
+func parseAdmissionResponse(out []byte) (*AdmissionResponse, error) {
+	resp := &AdmissionResponse{}
+	if out = bytes.TrimSpace(out); len(out) > 0 {
+		if err := json.Unmarshal(out, resp); err != nil {
+			return nil, fmt.Errorf("aufs: invalid admission hook response: %v", err)
+		}
+	}
+	return resp, nil
+}
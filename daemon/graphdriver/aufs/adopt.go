@@ -0,0 +1,89 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// Adopt registers the directory tree at dir, prepared outside of docker
+// (e.g. by an image builder or an unpacked OCI layer), as a new layer on
+// top of parent and returns its id. The tree is moved into the store
+// rather than copied, so it must live on the same filesystem as the
+// driver root.
+func (a *Driver) Adopt(dir, parent string) (string, error) {
+	id := stringid.GenerateRandomID()
+	if err := a.adopt(id, dir, parent); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// adopt moves dir into the store as the layer id.
+func (a *Driver) adopt(id, dir, parent string) error {
+	if parent != "" && !a.Exists(parent) {
+		return fmt.Errorf("aufs: cannot adopt %s: parent %s does not exist", dir, parent)
+	}
+	if err := validateAdoptTree(dir); err != nil {
+		return err
+	}
+
+	if err := a.Create(id, parent); err != nil {
+		return err
+	}
+	diff := a.layerPath("diff", id)
+	if err := os.Remove(diff); err != nil {
+		a.Remove(id)
+		return err
+	}
+	if err := os.Rename(dir, diff); err != nil {
+		a.Remove(id)
+		return fmt.Errorf("aufs: cannot adopt %s: %v", dir, err)
+	}
+	// Create only synced the empty directory the content replaced
+	if err := a.syncLayer(id); err != nil {
+		a.Remove(id)
+		return err
+	}
+	return nil
+}
+
+// validateAdoptTree checks that dir is owned by the daemon and that any
+// whiteouts in it are ones aufs understands.
+func validateAdoptTree(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("aufs: cannot adopt %s: not a directory", dir)
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("aufs: cannot adopt %s: owned by uid %d, expected %d", dir, st.Uid, os.Getuid())
+	}
+
+	return filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := fi.Name()
+		if !strings.HasPrefix(name, archive.WhiteoutPrefix) || p == dir {
+			return nil
+		}
+		rel, _ := filepath.Rel(dir, p)
+		if strings.HasPrefix(name, archive.WhiteoutMetaPrefix) && name != archive.WhiteoutOpaqueDir {
+			return fmt.Errorf("aufs: cannot adopt %s: unexpected aufs metadata %s", dir, rel)
+		}
+		if !fi.Mode().IsRegular() {
+			return fmt.Errorf("aufs: cannot adopt %s: whiteout %s is not a regular file", dir, rel)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,111 @@
+// +build linux
+
+package aufs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+)
+
+// Annotations are arbitrary key/value pairs attached to a layer and kept
+// in annotations/<id> next to the layers metadata.
+
+func (a *Driver) annotationsPath(id string) string {
+	return path.Join(a.rootPath(), "annotations", id)
+}
+
+// Annotations returns the annotations of the layer with the given id.
+func (a *Driver) Annotations(id string) (map[string]string, error) {
+	if !a.Exists(id) {
+		return nil, fmt.Errorf("aufs: unknown layer %s", id)
+	}
+	return readAnnotations(a.annotationsPath(id))
+}
+
+// Annotate merges the given annotations into the ones of the layer with
+// the given id. An empty value removes the key.
+func (a *Driver) Annotate(id string, annotations map[string]string) error {
+	a.annotationsLock.Lock()
+	defer a.annotationsLock.Unlock()
+
+	// Checked with the lock held, Remove must not race with the write
+	current, err := a.Annotations(id)
+	if err != nil {
+		return err
+	}
+	for k, v := range annotations {
+		if v == "" {
+			delete(current, k)
+			continue
+		}
+		current[k] = v
+	}
+
+	p := a.annotationsPath(id)
+	if len(current) == 0 {
+		if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(path.Dir(p), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// FindByAnnotation returns the sorted ids of the layers annotated with
+// key, and value if it is not empty.
+func (a *Driver) FindByAnnotation(key, value string) ([]string, error) {
+	dir := path.Join(a.rootPath(), "annotations")
+	ids, err := loadIds(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var found []string
+	for _, id := range ids {
+		if path.Ext(id) == ".tmp" {
+			continue
+		}
+		annotations, err := readAnnotations(path.Join(dir, id))
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := annotations[key]; ok && (value == "" || v == value) {
+			found = append(found, id)
+		}
+	}
+	sort.Strings(found)
+	return found, nil
+}
+
+func readAnnotations(p string) (map[string]string, error) {
+	annotations := make(map[string]string)
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return annotations, nil
+		}
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &annotations); err != nil {
+		return nil, fmt.Errorf("aufs: corrupted annotations %s: %v", p, err)
+	}
+	return annotations, nil
+}
@@ -5,10 +5,15 @@
 aufs driver directory structure
 
   .
+  ├── annotations // Optional key/value pairs attached to layers
+  │   └── 1
   ├── layers // Metadata of layers
   │   ├── 1
   │   ├── 2
   │   └── 3
+  ├── access // Last time each layer was used by a mount
+  ├── journal // Log of the layers added and removed over time
+  ├── staging // Layers dropped by provisioning tools, adopted at startup
   ├── diff  // Content of the layer
   │   ├── 1  // Contains layers that need to be mounted for the id
   │   ├── 2
@@ -24,20 +29,26 @@ package aufs
 
 import (
 	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/docker/docker/pkg/chrootarchive"
 	"github.com/docker/docker/pkg/directory"
+	"github.com/docker/docker/pkg/ioutils"
 	mountpk "github.com/docker/docker/pkg/mount"
 	"github.com/docker/docker/pkg/stringid"
 	"github.com/docker/libcontainer/label"
@@ -60,9 +71,36 @@ func init() {
 }
 
 type Driver struct {
-	root       string
-	sync.Mutex // Protects concurrent modification to active
-	active     map[string]int
+	root            string
+	options         aufsOptions
+	sync.Mutex      // Protects concurrent modification to active, idleSince, mountedBranches, bindExports, poisoned and accessTimes
+	active          map[string]int
+	idleSince       map[string]time.Time
+	mountedBranches map[string]int
+	bindExports     map[string]string // Read-only exports, target to id
+	poisoned        map[string]bool   // Layers with a hung mount or unmount
+	accessTimes     map[string]time.Time
+	accessDirty     bool // accessTimes changed since they were last written
+	stopReaper      chan struct{}
+	stopMetrics     chan struct{}
+	stopAccess      chan struct{}
+	stagingSignals  chan os.Signal
+	importHook      func() // Called after staged layers were adopted
+	pool            *dirPool
+	lock            *os.File // Held for as long as the driver uses root
+
+	journalLock sync.Mutex // Serializes appends to the journal
+	accessLock  sync.Mutex // Serializes writes of the access times
+
+	annotationsLock sync.Mutex // Serializes changes to the annotations
+
+	useLock sync.Mutex     // Protects using
+	using   map[string]int // Layers in use by operations that don't take the driver lock
+
+	countLock  sync.Mutex // Protects layerCount, countedAt and usage
+	layerCount int
+	usage      usage
+	countedAt  time.Time // When layerCount was last counted from disk
 }
 
 // New returns a new AUFS driver.
@@ -74,6 +112,11 @@ func Init(root string, options []string) (graphdriver.Driver, error) {
 		return nil, graphdriver.ErrNotSupported
 	}
 
+	opts, err := parseOptions(options)
+	if err != nil {
+		return nil, err
+	}
+
 	fsMagic, err := graphdriver.GetFSMagic(root)
 	if err != nil {
 		return nil, err
@@ -95,8 +138,15 @@ func Init(root string, options []string) (graphdriver.Driver, error) {
 	}
 
 	a := &Driver{
-		root:   root,
-		active: make(map[string]int),
+		root:            root,
+		options:         opts,
+		active:          make(map[string]int),
+		idleSince:       make(map[string]time.Time),
+		mountedBranches: make(map[string]int),
+		bindExports:     make(map[string]string),
+		poisoned:        make(map[string]bool),
+		accessTimes:     make(map[string]time.Time),
+		using:           make(map[string]int),
 	}
 
 	// Create the root aufs driver dir and return
@@ -109,15 +159,68 @@ func Init(root string, options []string) (graphdriver.Driver, error) {
 		return nil, err
 	}
 
+	if a.lock, err = lockRoot(root); err != nil {
+		return nil, err
+	}
+
 	if err := mountpk.MakePrivate(root); err != nil {
+		a.lock.Close()
 		return nil, err
 	}
 
 	for _, p := range paths {
 		if err := os.MkdirAll(path.Join(root, p), 0755); err != nil {
+			a.lock.Close()
 			return nil, err
 		}
 	}
+
+	if removed, err := a.Scavenge(false); err != nil {
+		logrus.Errorf("aufs: removing leftovers of interrupted removals: %v", err)
+	} else if len(removed) > 0 {
+		logrus.Infof("aufs: removed %d leftovers of interrupted removals", len(removed))
+	}
+
+	if opts.createPool > 0 {
+		if a.pool, err = newDirPool(root, opts.createPool); err != nil {
+			a.lock.Close()
+			return nil, err
+		}
+	}
+
+	if _, err := a.Refresh(); err != nil {
+		logrus.Errorf("aufs: counting layers: %v", err)
+	}
+	if err := a.seedJournal(); err != nil {
+		logrus.Errorf("aufs: starting the layer journal: %v", err)
+	}
+
+	if err := a.loadAccessTimes(); err != nil {
+		logrus.Errorf("aufs: loading access times: %v", err)
+	}
+	a.startAccessFlusher()
+	if err := a.loadExports(); err != nil {
+		logrus.Errorf("aufs: loading read-only exports: %v", err)
+	}
+
+	if opts.mountIdleTimeout > 0 {
+		a.startReaper()
+	}
+	if opts.metricsFile != "" {
+		a.startMetrics()
+	}
+	if _, err := a.ImportStaged(); err != nil {
+		logrus.Errorf("aufs: importing staged layers: %v", err)
+	}
+	a.startStagingTrap()
+
+	if a.layoutMismatch() {
+		go func() {
+			if _, err := a.MigrateLayout(opts.sharded); err != nil {
+				logrus.Errorf("aufs: migrating to the %s layout: %v", layoutName(opts.sharded), err)
+			}
+		}()
+	}
 	return a, nil
 }
 
@@ -152,24 +255,38 @@ func (*Driver) String() string {
 	return "aufs"
 }
 
-func (a *Driver) Status() [][2]string {
-	ids, _ := loadIds(path.Join(a.rootPath(), "layers"))
-	return [][2]string{
-		{"Root Dir", a.rootPath()},
-		{"Backing Filesystem", backingFs},
-		{"Dirs", fmt.Sprintf("%d", len(ids))},
-		{"Dirperm1 Supported", fmt.Sprintf("%v", useDirperm())},
-	}
-}
-
+// GetMetadata returns the view of the kernel of the mount of the layer,
+// if it is mounted. It is informational only, so failing to read it does
+// not fail the caller.
 func (a *Driver) GetMetadata(id string) (map[string]string, error) {
-	return nil, nil
+	stats, err := a.MountStats(id)
+	if err != nil {
+		logrus.Warnf("aufs: reading the kernel stats of %s: %v", stringid.TruncateID(id), err)
+		return nil, nil
+	}
+	if stats == nil {
+		return nil, nil
+	}
+	metadata := map[string]string{
+		"SI":       stats.SI,
+		"Branches": strings.Join(stats.Branches, ":"),
+	}
+	if stats.XinoPath != "" {
+		metadata["XinoPath"] = stats.XinoPath
+	}
+	if stats.Xino != "" {
+		metadata["Xino"] = stats.Xino
+	}
+	if stats.Plinks >= 0 {
+		metadata["Plinks"] = strconv.Itoa(stats.Plinks)
+	}
+	return metadata, nil
 }
 
 // Exists returns true if the given id is registered with
 // this driver
 func (a *Driver) Exists(id string) bool {
-	if _, err := os.Lstat(path.Join(a.rootPath(), "layers", id)); err != nil {
+	if _, err := os.Lstat(a.layerPath("layers", id)); err != nil {
 		return false
 	}
 	return true
@@ -178,42 +295,55 @@ func (a *Driver) Exists(id string) bool {
 // Three folders are created for each id
 // mnt, layers, and diff
 func (a *Driver) Create(id, parent string) error {
-	if err := a.createDirsFor(id); err != nil {
+	if err := a.admit(&AdmissionRequest{Op: "create", ID: id, Parent: parent}); err != nil {
 		return err
 	}
-	// Write the layers metadata
-	f, err := os.Create(path.Join(a.rootPath(), "layers", id))
-	if err != nil {
+	if parent != "" {
+		if err := a.checkRetired(id, parent); err != nil {
+			return err
+		}
+	}
+	if err := a.createDirsFor(id); err != nil {
 		return err
 	}
-	defer f.Close()
-
+	// Write the layers metadata
+	var parents []chainEntry
 	if parent != "" {
-		ids, err := getParentIds(a.rootPath(), parent)
+		self, ids, err := readChain(a.rootPath(), parent)
 		if err != nil {
 			return err
 		}
-
-		if _, err := fmt.Fprintln(f, parent); err != nil {
-			return err
-		}
-		for _, i := range ids {
-			if _, err := fmt.Fprintln(f, i); err != nil {
-				return err
-			}
-		}
+		parents = append([]chainEntry{self}, ids...)
+	}
+	existed := a.Exists(id)
+	if err := a.writeChain(chainEntry{id: id, size: -1}, parents); err != nil {
+		return err
+	}
+	if !existed {
+		a.countLayers(1)
+	}
+	if err := a.syncLayer(id); err != nil {
+		return err
 	}
+	a.record(journalAdd, id)
 	return nil
 }
 
 func (a *Driver) createDirsFor(id string) error {
-	paths := []string{
-		"mnt",
-		"diff",
+	mnt, err := a.createLayerPath("mnt", id)
+	if err != nil {
+		return err
+	}
+	diff, err := a.createLayerPath("diff", id)
+	if err != nil {
+		return err
+	}
+	if a.pool != nil && a.pool.claim(mnt, diff) {
+		return nil
 	}
 
-	for _, p := range paths {
-		if err := os.MkdirAll(path.Join(a.rootPath(), p, id), 0755); err != nil {
+	for _, p := range []string{mnt, diff} {
+		if err := os.MkdirAll(p, 0755); err != nil {
 			return err
 		}
 	}
@@ -222,17 +352,78 @@ func (a *Driver) createDirsFor(id string) error {
 
 // Unmount and remove the dir information
 func (a *Driver) Remove(id string) error {
+	if err := a.checkDependents(id); err != nil {
+		return err
+	}
+
 	// Protect the a.active from concurrent access
 	a.Lock()
+	tmpPaths, err := a.removeLocked(id)
+	a.Unlock()
+
+	for _, p := range tmpPaths {
+		os.RemoveAll(p)
+	}
+	return err
+}
+
+// CheckRemove returns the error Remove would refuse the layer with, without
+// removing anything, so that callers can check before they drop their own
+// records of the layer.
+func (a *Driver) CheckRemove(id string) error {
+	if err := a.checkDependents(id); err != nil {
+		return err
+	}
+	a.Lock()
 	defer a.Unlock()
+	return a.checkRemovable(id)
+}
 
+// checkDependents fails, when removals are verified, if other layers are
+// built on id.
+func (a *Driver) checkDependents(id string) error {
+	if !a.options.verifyRemove {
+		return nil
+	}
+	dependents, err := a.Dependents(id)
+	if err != nil {
+		return err
+	}
+	if len(dependents) > 0 {
+		return ErrLayerInUse{ID: id, Dependents: dependents}
+	}
+	return nil
+}
+
+// checkRemovable fails if id is protected from removal. Must be called
+// with the driver lock held.
+func (a *Driver) checkRemovable(id string) error {
+	if targets := a.exportedAt(id); len(targets) > 0 {
+		return fmt.Errorf("aufs: cannot remove %s: exported read-only at %s", id, strings.Join(targets, ", "))
+	}
+	if pinned, err := a.isPinned(id); err != nil {
+		return err
+	} else if pinned {
+		return ErrLayerPinned{ID: id}
+	}
+	return nil
+}
+
+// removeLocked unmounts id, moves its directories out of the way and
+// removes its metadata. It returns the moved directories, which are left
+// for the caller to delete once the driver lock is released. Must be
+// called with the driver lock held.
+func (a *Driver) removeLocked(id string) ([]string, error) {
 	if a.active[id] != 0 {
 		logrus.Errorf("Removing active id %s", id)
 	}
+	if err := a.checkRemovable(id); err != nil {
+		return nil, err
+	}
 
 	// Make sure the dir is umounted first
 	if err := a.unmount(id); err != nil {
-		return err
+		return nil, err
 	}
 	tmpDirs := []string{
 		"mnt",
@@ -242,54 +433,91 @@ func (a *Driver) Remove(id string) error {
 	// Atomically remove each directory in turn by first moving it out of the
 	// way (so that docker doesn't find it anymore) before doing removal of
 	// the whole tree.
+	var tmpPaths []string
 	for _, p := range tmpDirs {
 
-		realPath := path.Join(a.rootPath(), p, id)
-		tmpPath := path.Join(a.rootPath(), p, fmt.Sprintf("%s-removing", id))
+		realPath := a.layerPath(p, id)
+		tmpPath := path.Join(path.Dir(realPath), fmt.Sprintf("%s-removing", id))
 		if err := os.Rename(realPath, tmpPath); err != nil && !os.IsNotExist(err) {
-			return err
+			return tmpPaths, err
 		}
-		defer os.RemoveAll(tmpPath)
+		tmpPaths = append(tmpPaths, tmpPath)
 	}
 
 	// Remove the layers file for the id
-	if err := os.Remove(path.Join(a.rootPath(), "layers", id)); err != nil && !os.IsNotExist(err) {
-		return err
-	}
-	return nil
+	if err := os.Remove(a.layerPath("layers", id)); err == nil {
+		a.countLayers(-1)
+	} else if !os.IsNotExist(err) {
+		return tmpPaths, err
+	}
+	forgetParentIds(a.rootPath(), id)
+	if _, ok := a.accessTimes[id]; ok {
+		delete(a.accessTimes, id)
+		a.accessDirty = true
+	}
+	// The layers file is gone, so no Annotate can recreate them afterwards
+	a.annotationsLock.Lock()
+	err := os.Remove(a.annotationsPath(id))
+	a.annotationsLock.Unlock()
+	if err != nil && !os.IsNotExist(err) {
+		return tmpPaths, err
+	}
+	a.record(journalRemove, id)
+	return tmpPaths, nil
 }
 
 // Return the rootfs path for the id
 // This will mount the dir at it's given path
 func (a *Driver) Get(id, mountLabel string) (string, error) {
+	// Protect the a.active from concurrent access
+	a.Lock()
+	defer a.Unlock()
+
+	// Resolved with the lock held, so that MigrateLayout cannot move the
+	// layers file away in between
 	ids, err := getParentIds(a.rootPath(), id)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return "", err
 		}
+		// A layer without parents has a layers file too, mounting one
+		// without it would hide its parents
+		if _, err := os.Lstat(a.layerPath("diff", id)); err == nil {
+			return "", fmt.Errorf("aufs: layers file of %s is missing", id)
+		}
 		ids = []string{}
 	}
 
-	// Protect the a.active from concurrent access
-	a.Lock()
-	defer a.Unlock()
-
 	count := a.active[id]
 
 	// If a dir does not have a parent ( no layers )do not try to mount
 	// just return the diff path to the data
-	out := path.Join(a.rootPath(), "diff", id)
+	out := a.layerPath("diff", id)
 	if len(ids) > 0 {
-		out = path.Join(a.rootPath(), "mnt", id)
+		out = a.layerPath("mnt", id)
 
 		if count == 0 {
-			if err := a.mount(id, mountLabel); err != nil {
+			// The hook runs outside of the lock, a slow one must not hold
+			// up every other mount
+			a.Unlock()
+			req := &AdmissionRequest{Op: "mount", ID: id, MountLabel: mountLabel}
+			err := a.admit(req)
+			a.Lock()
+			if err != nil {
 				return "", err
 			}
+			// Another Get may have mounted the layer in the meantime
+			if count = a.active[id]; count == 0 {
+				if err := a.mount(id, req.MountLabel); err != nil {
+					return "", err
+				}
+			}
 		}
 	}
 
 	a.active[id] = count + 1
+	a.updateUsage()
+	a.touch(append(ids, id), time.Now())
 
 	return out, nil
 }
@@ -309,6 +537,7 @@ func (a *Driver) Put(id string) error {
 		}
 		delete(a.active, id)
 	}
+	a.updateUsage()
 	return nil
 }
 
@@ -316,14 +545,35 @@ func (a *Driver) Put(id string) error {
 // layer and its parent layer which may be "".
 func (a *Driver) Diff(id, parent string) (archive.Archive, error) {
 	// AUFS doesn't need the parent layer to produce a diff.
-	return archive.TarWithOptions(path.Join(a.rootPath(), "diff", id), &archive.TarOptions{
+	return a.diff(id, a.options.whiteouts)
+}
+
+// diff produces an archive of the layer with the given id, with whiteouts
+// in the given format. The aufs metadata at the top of the branch is
+// always excluded; opaque directory markers further down are kept, as
+// they use the same name as the OCI opaque whiteout and carry the
+// "directory replaced" meaning over to other drivers.
+func (a *Driver) diff(id string, whiteouts archive.WhiteoutFormat) (archive.Archive, error) {
+	release := a.use(id)
+	arch, err := archive.TarWithOptions(a.layerPath("diff", id), &archive.TarOptions{
 		Compression:     archive.Uncompressed,
-		ExcludePatterns: []string{".wh..wh.*"},
+		ExcludePatterns: []string{archive.WhiteoutMetaPrefix + "*"},
+		WhiteoutFormat:  whiteouts,
 	})
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// The directory is read for as long as the archive is
+	return ioutils.NewReadCloserWrapper(arch, func() error {
+		err := arch.Close()
+		release()
+		return err
+	}), nil
 }
 
 func (a *Driver) applyDiff(id string, diff archive.ArchiveReader) error {
-	return chrootarchive.Untar(diff, path.Join(a.rootPath(), "diff", id), nil)
+	return chrootarchive.Untar(diff, a.layerPath("diff", id), nil)
 }
 
 // DiffSize calculates the changes between the specified id
@@ -331,7 +581,8 @@ func (a *Driver) applyDiff(id string, diff archive.ArchiveReader) error {
 // relative to its base filesystem directory.
 func (a *Driver) DiffSize(id, parent string) (size int64, err error) {
 	// AUFS doesn't need the parent layer to calculate the diff size.
-	return directory.Size(path.Join(a.rootPath(), "diff", id))
+	defer a.use(id)()
+	return directory.Size(a.layerPath("diff", id))
 }
 
 // ApplyDiff extracts the changeset from the given diff into the
@@ -339,11 +590,35 @@ func (a *Driver) DiffSize(id, parent string) (size int64, err error) {
 // new layer in bytes.
 func (a *Driver) ApplyDiff(id, parent string, diff archive.ArchiveReader) (size int64, err error) {
 	// AUFS doesn't need the parent id to apply the diff.
-	if err = a.applyDiff(id, diff); err != nil {
+	defer a.use(id)()
+	h := sha256.New()
+	if err = a.applyDiff(id, io.TeeReader(diff, h)); err != nil {
+		return
+	}
+	// Include whatever Untar left unread after the end-of-archive marker
+	if _, err = io.Copy(h, diff); err != nil {
+		return
+	}
+	if size, err = a.DiffSize(id, parent); err != nil {
 		return
 	}
 
-	return a.DiffSize(id, parent)
+	// Record what the layer was applied from, for its future children
+	_, parents, err := readChain(a.rootPath(), id)
+	if err != nil {
+		return
+	}
+	self := chainEntry{id: id, digest: "sha256:" + hex.EncodeToString(h.Sum(nil)), size: size}
+	if self.contentDigest, self.contentSize, err = a.contentDigest(id); err != nil {
+		return
+	}
+	if err = a.writeChain(self, parents); err != nil {
+		return
+	}
+	if err = a.syncLayer(id); err != nil {
+		return
+	}
+	return size, nil
 }
 
 // Changes produces a list of changes between the specified layer
@@ -351,11 +626,25 @@ func (a *Driver) ApplyDiff(id, parent string, diff archive.ArchiveReader) (size
 func (a *Driver) Changes(id, parent string) ([]archive.Change, error) {
 	// AUFS doesn't have snapshots, so we need to get changes from all parent
 	// layers.
+	parents, err := getParentIds(a.rootPath(), id)
+	if err != nil {
+		return nil, err
+	}
+	defer a.use(append(parents, id)...)()
 	layers, err := a.getParentLayerPaths(id)
 	if err != nil {
 		return nil, err
 	}
-	return archive.Changes(layers, path.Join(a.rootPath(), "diff", id))
+	return archive.Changes(layers, a.layerPath("diff", id))
+}
+
+// ApproximateChanges is a cheaper Changes that only looks at the diff of
+// the layer, not at the ones of its parents, for interactive use on long
+// chains. Files the layer modified are reported as added, and what an
+// opaque directory hides from the parents is not reported as deleted.
+func (a *Driver) ApproximateChanges(id string) ([]archive.Change, error) {
+	defer a.use(id)()
+	return archive.Changes(nil, a.layerPath("diff", id))
 }
 
 func (a *Driver) getParentLayerPaths(id string) ([]string, error) {
@@ -367,7 +656,7 @@ func (a *Driver) getParentLayerPaths(id string) ([]string, error) {
 
 	// Get the diff paths for all the parent ids
 	for i, p := range parentIds {
-		layers[i] = path.Join(a.rootPath(), "diff", p)
+		layers[i] = a.layerPath("diff", p)
 	}
 	return layers, nil
 }
@@ -379,18 +668,26 @@ func (a *Driver) mount(id, mountLabel string) error {
 	}
 
 	var (
-		target = path.Join(a.rootPath(), "mnt", id)
-		rw     = path.Join(a.rootPath(), "diff", id)
+		target = a.layerPath("mnt", id)
+		rw     = a.layerPath("diff", id)
 	)
 
 	layers, err := a.getParentLayerPaths(id)
 	if err != nil {
 		return err
 	}
+	if err := a.checkMountBudget(id, len(layers)+1); err != nil {
+		return err
+	}
 
-	if err := a.aufsMount(layers, rw, target, mountLabel); err != nil {
+	err = a.watchdog(id, "mount", func() error {
+		return a.aufsMount(layers, rw, target, mountLabel)
+	})
+	if err != nil {
 		return fmt.Errorf("error creating aufs mount to %s: %v", target, err)
 	}
+	a.mountedBranches[id] = len(layers) + 1
+	a.updateUsage()
 	return nil
 }
 
@@ -398,29 +695,67 @@ func (a *Driver) unmount(id string) error {
 	if mounted, err := a.mounted(id); err != nil || !mounted {
 		return err
 	}
-	target := path.Join(a.rootPath(), "mnt", id)
-	return Unmount(target)
+	target := a.layerPath("mnt", id)
+	if err := a.watchdog(id, "unmount", func() error { return Unmount(target) }); err != nil {
+		return err
+	}
+	delete(a.mountedBranches, id)
+	a.updateUsage()
+	return nil
 }
 
 func (a *Driver) mounted(id string) (bool, error) {
-	target := path.Join(a.rootPath(), "mnt", id)
+	target := a.layerPath("mnt", id)
 	return mountpk.Mounted(target)
 }
 
 // During cleanup aufs needs to unmount all mountpoints
 func (a *Driver) Cleanup() error {
+	if a.stopReaper != nil {
+		close(a.stopReaper)
+	}
+	if a.stopMetrics != nil {
+		close(a.stopMetrics)
+	}
+	if a.stopAccess != nil {
+		close(a.stopAccess)
+	}
+	if err := a.flushAccessTimes(); err != nil {
+		logrus.Errorf("aufs: writing access times: %v", err)
+	}
+	a.stopStagingTrap()
+
 	ids, err := loadIds(path.Join(a.rootPath(), "layers"))
 	if err != nil {
 		return err
 	}
 
+	a.Lock()
+	if err := a.unbindAll(); err != nil {
+		logrus.Errorf("aufs: releasing read-only exports: %v", err)
+	}
 	for _, id := range ids {
 		if err := a.unmount(id); err != nil {
 			logrus.Errorf("Unmounting %s: %s", stringid.TruncateID(id), err)
 		}
 	}
+	a.Unlock()
+
+	err = mountpk.Unmount(a.root)
+	if a.lock != nil {
+		a.lock.Close()
+	}
+	return err
+}
 
-	return mountpk.Unmount(a.root)
+// mountOptionRoom returns how many bytes of the page-sized mount data are
+// left for the branches once xino & mountLabel are accounted for.
+func mountOptionRoom(mountLabel string) int {
+	offset := 54
+	if useDirperm() {
+		offset += len("dirperm1")
+	}
+	return syscall.Getpagesize() - len(mountLabel) - offset
 }
 
 func (a *Driver) aufsMount(ro []string, rw, target, mountLabel string) (err error) {
@@ -432,12 +767,7 @@ func (a *Driver) aufsMount(ro []string, rw, target, mountLabel string) (err erro
 
 	// Mount options are clipped to page size(4096 bytes). If there are more
 	// layers then these are remounted individually using append.
-
-	offset := 54
-	if useDirperm() {
-		offset += len("dirperm1")
-	}
-	b := make([]byte, syscall.Getpagesize()-len(mountLabel)-offset) // room for xino & mountLabel
+	b := make([]byte, mountOptionRoom(mountLabel))
 	bp := copy(b, fmt.Sprintf("br:%s=rw", rw))
 
 	firstMount := true
@@ -3,16 +3,29 @@
 package aufs
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/docker/docker/daemon/graphdriver"
 	"github.com/docker/docker/pkg/archive"
+	mountpk "github.com/docker/docker/pkg/mount"
 	"github.com/docker/docker/pkg/reexec"
 )
 
@@ -46,6 +59,27 @@ func newDriver(t *testing.T) *Driver {
 	return d.(*Driver)
 }
 
+// newLocalDriver returns a driver over the scratch root without probing
+// for aufs support, for tests that only exercise the on-disk layout and
+// never mount anything.
+func newLocalDriver(t testing.TB) *Driver {
+	for _, p := range []string{"mnt", "diff", "layers"} {
+		if err := os.MkdirAll(path.Join(tmp, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return &Driver{
+		root:            tmp,
+		active:          make(map[string]int),
+		idleSince:       make(map[string]time.Time),
+		mountedBranches: make(map[string]int),
+		bindExports:     make(map[string]string),
+		poisoned:        make(map[string]bool),
+		accessTimes:     make(map[string]time.Time),
+		using:           make(map[string]int),
+	}
+}
+
 func TestNewDriver(t *testing.T) {
 	if err := os.MkdirAll(tmp, 0755); err != nil {
 		t.Fatal(err)
@@ -90,11 +124,37 @@ func TestNewDriverFromExistingDir(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	testInit(tmp, t)
-	testInit(tmp, t)
+	d := testInit(tmp, t)
+	if err := d.Cleanup(); err != nil {
+		t.Fatal(err)
+	}
+	d = testInit(tmp, t)
+	d.Cleanup()
 	os.RemoveAll(tmp)
 }
 
+func TestLockRoot(t *testing.T) {
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	lock, err := lockRoot(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := lockRoot(tmp); err == nil || !strings.Contains(err.Error(), fmt.Sprintf("pid %d", os.Getpid())) {
+		t.Fatalf("Expected the root to be reported in use by this process, got %v", err)
+	}
+
+	lock.Close()
+	lock, err = lockRoot(tmp)
+	if err != nil {
+		t.Fatalf("Expected the lock to be free once released: %v", err)
+	}
+	lock.Close()
+}
+
 func TestCreateNewDir(t *testing.T) {
 	d := newDriver(t)
 	defer os.RemoveAll(tmp)
@@ -168,6 +228,25 @@ func TestGetWithoutParent(t *testing.T) {
 	}
 }
 
+func TestGetMissingLayersFile(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(tmp, "layers", "1")); err != nil {
+		t.Fatal(err)
+	}
+	forgetParentIds(tmp, "1")
+	if _, err := d.Get("1", ""); err == nil {
+		t.Fatal("Expected an error for a layer without its layers file")
+	}
+	if d.active["1"] != 0 {
+		t.Fatalf("Expected 1 not to be referenced, got %d", d.active["1"])
+	}
+}
+
 func TestCleanupWithNoDirs(t *testing.T) {
 	d := newDriver(t)
 	defer os.RemoveAll(tmp)
@@ -348,6 +427,92 @@ func TestGetDiff(t *testing.T) {
 	}
 }
 
+func TestDiffKeepsOpaqueMarkers(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	diffPath := path.Join(tmp, "diff", "1")
+	for _, p := range []string{"dir", archive.WhiteoutLinkDir} {
+		if err := os.MkdirAll(path.Join(diffPath, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range []string{"dir/" + archive.WhiteoutOpaqueDir, archive.WhiteoutLinkDir + "/link"} {
+		if err := ioutil.WriteFile(path.Join(diffPath, p), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, err := d.Diff("1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	names := map[string]bool{}
+	tr := tar.NewReader(a)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[path.Clean(hdr.Name)] = true
+	}
+	if !names["dir/"+archive.WhiteoutOpaqueDir] {
+		t.Fatalf("Expected opaque marker in diff, got %v", names)
+	}
+	for n := range names {
+		if strings.HasPrefix(n, archive.WhiteoutLinkDir) {
+			t.Fatalf("Unexpected aufs metadata %s in diff", n)
+		}
+	}
+
+	// The OCI format drops aufs metadata further down and keeps the
+	// opaque markers
+	if err := ioutil.WriteFile(path.Join(diffPath, "dir", archive.WhiteoutMetaPrefix+"aufs"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, whiteouts := range []string{"aufs", "oci"} {
+		opts, err := parseOptions([]string{"aufs.whiteouts=" + whiteouts})
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.options = opts
+		a, err := d.Diff("1", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		names := map[string]bool{}
+		tr := tar.NewReader(a)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names[path.Clean(hdr.Name)] = true
+		}
+		a.Close()
+		if !names["dir/"+archive.WhiteoutOpaqueDir] {
+			t.Fatalf("Expected opaque marker in the %s diff, got %v", whiteouts, names)
+		}
+		if meta := names["dir/"+archive.WhiteoutMetaPrefix+"aufs"]; meta != (whiteouts == "aufs") {
+			t.Fatalf("Unexpected aufs metadata in the %s diff: %v", whiteouts, names)
+		}
+	}
+	if _, err := parseOptions([]string{"aufs.whiteouts=overlay"}); err == nil {
+		t.Fatal("Expected an error for an unknown whiteout format")
+	}
+}
+
 func TestChanges(t *testing.T) {
 	d := newDriver(t)
 	defer os.RemoveAll(tmp)
@@ -732,3 +897,2498 @@ func TestMountMoreThan42LayersMatchingPathLength(t *testing.T) {
 		zeroes += "0"
 	}
 }
+
+func TestParseOptions(t *testing.T) {
+	opts, err := parseOptions([]string{
+		"aufs.mountidletimeout=10m",
+		"aufs.durability=Full",
+		"aufs.hook=/usr/local/bin/admit",
+		"aufs.hookfailopen=true",
+		"aufs.mounttimeout=2m",
+		"aufs.layout=Sharded",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.mountIdleTimeout != 10*time.Minute {
+		t.Fatalf("Expected idle timeout of 10m, got %s", opts.mountIdleTimeout)
+	}
+	if opts.durability != durabilityFull {
+		t.Fatalf("Expected full durability, got %s", opts.durability)
+	}
+	if !opts.sharded {
+		t.Fatal("Expected the sharded layout")
+	}
+	if opts.mountTimeout != 2*time.Minute {
+		t.Fatalf("Expected mount timeout of 2m, got %s", opts.mountTimeout)
+	}
+	if opts.hook == nil || opts.hook.path != "/usr/local/bin/admit" || !opts.hook.failOpen || opts.hook.timeout != defaultHookTimeout {
+		t.Fatalf("Unexpected hook configuration %+v", opts.hook)
+	}
+
+	for _, invalid := range []string{
+		"aufs.mountidletimeout",
+		"aufs.mountidletimeout=forever",
+		"aufs.mountidletimeout=-1s",
+		"aufs.durability=paranoid",
+		"aufs.hooktimeout=0s",
+		"aufs.hookfailopen=true",
+		"aufs.mounttimeout=-1s",
+		"aufs.layout=nested",
+		"aufs.metricsfile=aufs.prom",
+		"aufs.metricsinterval=1m",
+		"aufs.unknown=1",
+	} {
+		if _, err := parseOptions([]string{invalid}); err == nil {
+			t.Fatalf("Expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func TestIdleMounts(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options.mountIdleTimeout = time.Minute
+
+	d.active["busy"] = 1
+	start := time.Now()
+
+	if ids := d.idleMounts([]string{"busy", "idle"}, start); len(ids) != 0 {
+		t.Fatalf("Nothing should be reaped on first sight, got %v", ids)
+	}
+	if ids := d.idleMounts([]string{"busy", "idle"}, start.Add(30*time.Second)); len(ids) != 0 {
+		t.Fatalf("Nothing should be reaped before the timeout, got %v", ids)
+	}
+	ids := d.idleMounts([]string{"busy", "idle"}, start.Add(time.Minute))
+	if len(ids) != 1 || ids[0] != "idle" {
+		t.Fatalf("Expected only idle to be reaped, got %v", ids)
+	}
+
+	// Once unmounted behind our back, the id is forgotten
+	d.idleMounts([]string{"busy"}, start.Add(2*time.Minute))
+	if _, ok := d.idleSince["idle"]; ok {
+		t.Fatalf("Expected idle to be forgotten once unmounted")
+	}
+}
+
+func TestRemoveDryRun(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := d.RemoveDryRun("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &RemoveReport{
+		ID:          "1",
+		Directories: []string{path.Join(tmp, "mnt", "1"), path.Join(tmp, "diff", "1")},
+		Metadata:    []string{path.Join(tmp, "layers", "1")},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, r)
+	}
+	if _, err := json.Marshal(r); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing was touched
+	for _, p := range append(r.Directories, r.Metadata...) {
+		if _, err := os.Lstat(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err = d.RemoveDryRun("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Directories) != 0 || len(r.Metadata) != 0 || len(r.Unmount) != 0 {
+		t.Fatalf("Expected an empty report for an unknown id, got %+v", r)
+	}
+
+	// What refuses the removal is reported too
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	d.options.verifyRemove = true
+	d.bindExports["/srv/export"] = "1"
+	r, err = d.RemoveDryRun("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(r.Dependents, []string{"2"}) || !reflect.DeepEqual(r.Exports, []string{"/srv/export"}) {
+		t.Fatalf("Expected the dependent and the export to be reported, got %+v", r)
+	}
+}
+
+func TestAdopt(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("base", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	src := path.Join(tmpOuter, "adopt")
+	if err := os.MkdirAll(path.Join(src, "etc"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+	if err := ioutil.WriteFile(path.Join(src, "etc", "hostname"), []byte("adopted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(src, "etc", archive.WhiteoutOpaqueDir), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := d.Adopt(src, "base")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(src); !os.IsNotExist(err) {
+		t.Fatalf("Expected %s to be moved into the store, got %v", src, err)
+	}
+	content, err := ioutil.ReadFile(path.Join(tmp, "diff", id, "etc", "hostname"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "adopted" {
+		t.Fatalf("Unexpected content %q", content)
+	}
+	ids, err := getParentIds(tmp, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != "base" {
+		t.Fatalf("Expected base as the only parent, got %v", ids)
+	}
+}
+
+func TestAdoptInvalidTree(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	src := path.Join(tmpOuter, "adopt")
+	if err := os.MkdirAll(path.Join(src, archive.WhiteoutPrefix+"dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(src)
+
+	if _, err := d.Adopt(src, ""); err == nil {
+		t.Fatal("Expected a directory whiteout to be rejected")
+	}
+	if _, err := d.Adopt(src, "missing"); err == nil {
+		t.Fatal("Expected a missing parent to be rejected")
+	}
+	if _, err := os.Lstat(src); err != nil {
+		t.Fatalf("Rejected tree should be left in place: %v", err)
+	}
+}
+
+func TestOCILayoutRoundTrip(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("base", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("top", "base"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "diff", "base", "a"), []byte("base"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "diff", "top", archive.WhiteoutPrefix+"a"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	layout := path.Join(tmpOuter, "oci")
+	defer os.RemoveAll(layout)
+	if err := d.ExportOCILayout("top", layout); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"oci-layout", "index.json"} {
+		if _, err := os.Stat(path.Join(layout, p)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, err := d.ImportOCILayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("Expected 2 layers, got %v", ids)
+	}
+	content, err := ioutil.ReadFile(path.Join(tmp, "diff", ids[0], "a"))
+	if err != nil || string(content) != "base" {
+		t.Fatalf("Unexpected base content %q: %v", content, err)
+	}
+	if _, err := os.Lstat(path.Join(tmp, "diff", ids[1], archive.WhiteoutPrefix+"a")); err != nil {
+		t.Fatalf("Expected the whiteout to be imported: %v", err)
+	}
+	parents, err := getParentIds(tmp, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 1 || parents[0] != ids[0] {
+		t.Fatalf("Expected %s as parent, got %v", ids[0], parents)
+	}
+	// The digests of the blobs are recorded like ApplyDiff does
+	self, chain, err := readChain(tmp, ids[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(self.digest, "sha256:") || self.size < 0 {
+		t.Fatalf("Expected the digest and size of the layer to be recorded, got %+v", self)
+	}
+	if len(chain) != 1 || !strings.HasPrefix(chain[0].digest, "sha256:") {
+		t.Fatalf("Expected the digest of the parent to be recorded, got %+v", chain)
+	}
+}
+
+// writeGzipOCILayout writes an OCI layout to dir with one gzipped layer
+// holding a file of the given content, followed by the given layers, and
+// returns the digest of the uncompressed tar.
+func writeGzipOCILayout(t *testing.T, dir, content string, more ...ociDescriptor) string {
+	if err := os.MkdirAll(path.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := archive.Generate("file", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw, compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	if _, err := io.Copy(io.MultiWriter(&raw, gz), diff); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	desc, err := writeOCIBlob(dir, ociMediaTypeLayerGz, &compressed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := writeOCIJSON(dir, ociMediaTypeManifest, ociManifest{SchemaVersion: 2, Layers: append([]ociDescriptor{desc}, more...)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := json.Marshal(ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{manifest}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(dir, "index.json"), index, 0644); err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(raw.Bytes())
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func TestImportOCILayoutGzip(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	layout := path.Join(tmpOuter, "oci")
+	defer os.RemoveAll(layout)
+	diffID := writeGzipOCILayout(t, layout, "content")
+
+	ids, err := d.ImportOCILayout(layout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("Expected 1 layer, got %v", ids)
+	}
+	content, err := ioutil.ReadFile(path.Join(tmp, "diff", ids[0], "file"))
+	if err != nil || string(content) != "content" {
+		t.Fatalf("Unexpected content %q: %v", content, err)
+	}
+	// The diff id is recorded, not the digest of the compressed blob
+	self, _, err := readChain(tmp, ids[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if self.digest != diffID {
+		t.Fatalf("Expected digest %s, got %s", diffID, self.digest)
+	}
+}
+
+func TestImportOCILayoutCleansUp(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	layout := path.Join(tmpOuter, "oci")
+	defer os.RemoveAll(layout)
+	missing := ociDescriptor{
+		MediaType: ociMediaTypeLayer,
+		Digest:    "sha256:" + strings.Repeat("0", 64),
+	}
+	writeGzipOCILayout(t, layout, "content", missing)
+
+	if ids, err := d.ImportOCILayout(layout); err == nil {
+		t.Fatalf("Expected the missing blob to fail the import, got %v", ids)
+	}
+	for _, p := range []string{"diff", "layers"} {
+		entries, err := ioutil.ReadDir(path.Join(tmp, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("Expected the layers of the failed import to be removed, found %d in %s", len(entries), p)
+		}
+	}
+}
+
+func TestApplyDiffWithFullDurability(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options.durability = durabilityFull
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "diff", "1", "file"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := d.Diff("1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer diff.Close()
+
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ApplyDiff("2", "1", diff); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(tmp, "diff", "2", "file")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportDiff(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "2"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "diff", "1", "base"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "diff", "3", "rw"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	arch, manifest, err := d.ExportDiff("3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer arch.Close()
+	if !reflect.DeepEqual(manifest.Parents, []string{"2", "1"}) {
+		t.Fatalf("Unexpected parents %v", manifest.Parents)
+	}
+
+	tr := tar.NewReader(arch)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if path.Clean(hdr.Name) == "base" {
+			t.Fatal("Export should not contain files of the image layers")
+		}
+	}
+
+	if err := os.Remove(path.Join(tmp, "layers", "1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := d.ExportDiff("3"); err == nil {
+		t.Fatal("Expected an error exporting on top of a missing layer")
+	}
+}
+
+func writeHook(t *testing.T, script string) string {
+	p := path.Join(tmpOuter, "hook")
+	if err := ioutil.WriteFile(p, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestAdmissionHook(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	hook := writeHook(t, `grep -q '"ID":"denied"' && { echo "not scanned" >&2; exit 1; }; exit 0`)
+	defer os.Remove(hook)
+	d.options.hook = &admissionHook{path: hook, timeout: defaultHookTimeout}
+
+	if err := d.Create("allowed", ""); err != nil {
+		t.Fatal(err)
+	}
+	err := d.Create("denied", "")
+	denied, ok := err.(ErrAdmissionDenied)
+	if !ok {
+		t.Fatalf("Expected the create to be denied, got %v", err)
+	}
+	if denied.Reason != "not scanned" {
+		t.Fatalf("Expected the hook's reason, got %q", denied.Reason)
+	}
+	if d.Exists("denied") {
+		t.Fatal("Denied layer should not have been created")
+	}
+}
+
+func TestAdmissionHookMutatesMountLabel(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	hook := writeHook(t, `echo '{"MountLabel":"system_u:object_r:svirt_sandbox_file_t:s0"}'`)
+	defer os.Remove(hook)
+	d.options.hook = &admissionHook{path: hook, timeout: defaultHookTimeout}
+
+	req := &AdmissionRequest{Op: "mount", ID: "1"}
+	if err := d.admit(req); err != nil {
+		t.Fatal(err)
+	}
+	if req.MountLabel != "system_u:object_r:svirt_sandbox_file_t:s0" {
+		t.Fatalf("Expected the mount label to be replaced, got %q", req.MountLabel)
+	}
+}
+
+func TestAdmissionHookChain(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "2"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Annotate("1", map[string]string{"scan": "passed"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Annotate("3", map[string]string{"team": "infra"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got AdmissionRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = AdmissionRequest{}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Error(err)
+		}
+		// Deny anything built on a layer that was not scanned
+		for _, l := range got.Chain {
+			if l.Annotations["scan"] != "passed" {
+				http.Error(w, "layer "+l.ID+" not scanned", http.StatusForbidden)
+				return
+			}
+		}
+	}))
+	defer server.Close()
+	d.options.hook = &admissionHook{path: server.URL, timeout: defaultHookTimeout}
+
+	if err := d.admit(&AdmissionRequest{Op: "mount", ID: "3"}); err == nil {
+		t.Fatal("Expected the mount of 3 to be denied, 2 was not scanned")
+	} else if denied, ok := err.(ErrAdmissionDenied); !ok || denied.Reason != "layer 2 not scanned" {
+		t.Fatalf("Expected the hook's reason, got %v", err)
+	}
+	expected := AdmissionRequest{
+		Op:          "mount",
+		ID:          "3",
+		Annotations: map[string]string{"team": "infra"},
+		Chain: []AdmissionLayer{
+			{ID: "2"},
+			{ID: "1", Annotations: map[string]string{"scan": "passed"}},
+		},
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, got)
+	}
+
+	if err := d.Create("4", "1"); err != nil {
+		t.Fatalf("Expected a create on a scanned layer to be admitted: %v", err)
+	}
+	if len(got.Chain) != 1 || got.Chain[0].ID != "1" {
+		t.Fatalf("Expected the chain of the create to be 1, got %+v", got.Chain)
+	}
+}
+
+func TestAdmissionHookFailure(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	hook := writeHook(t, "sleep 5")
+	defer os.Remove(hook)
+	d.options.hook = &admissionHook{path: hook, timeout: 50 * time.Millisecond}
+
+	if err := d.Create("1", ""); err == nil {
+		t.Fatal("Expected a hung hook to fail closed")
+	}
+
+	d.options.hook.failOpen = true
+	if err := d.Create("1", ""); err != nil {
+		t.Fatalf("Expected a hung hook to fail open: %v", err)
+	}
+
+	d.options.hook.path = path.Join(tmpOuter, "missing-hook")
+	if err := d.Create("2", ""); err != nil {
+		t.Fatalf("Expected a missing hook to fail open: %v", err)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := d.Create(id, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Annotate("1", map[string]string{"base": "base:2024-06", "team": "infra"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Annotate("2", map[string]string{"base": "base:2024-07"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Annotate("missing", map[string]string{"base": "x"}); err == nil {
+		t.Fatal("Expected an error annotating an unknown layer")
+	}
+
+	annotations, err := d.Annotations("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(annotations, map[string]string{"base": "base:2024-06", "team": "infra"}) {
+		t.Fatalf("Unexpected annotations %v", annotations)
+	}
+
+	ids, err := d.FindByAnnotation("base", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "2"}) {
+		t.Fatalf("Expected 1 and 2, got %v", ids)
+	}
+	ids, err = d.FindByAnnotation("base", "base:2024-06")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("Expected 1, got %v", ids)
+	}
+
+	// Empty values remove keys
+	if err := d.Annotate("1", map[string]string{"team": ""}); err != nil {
+		t.Fatal(err)
+	}
+	if annotations, _ := d.Annotations("1"); len(annotations) != 1 {
+		t.Fatalf("Expected team to be removed, got %v", annotations)
+	}
+
+	if err := d.Remove("2"); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := d.FindByAnnotation("base", ""); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("Expected annotations to go away with the layer, got %v", ids)
+	}
+	if err := d.Annotate("2", map[string]string{"team": "storage"}); err == nil {
+		t.Fatal("Expected an error annotating a removed layer")
+	}
+
+	// Concurrent changes are all kept
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := d.Annotate("1", map[string]string{fmt.Sprintf("key%d", i): "value"}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if annotations, _ := d.Annotations("1"); len(annotations) != 11 {
+		t.Fatalf("Expected 11 annotations, got %v", annotations)
+	}
+}
+
+func TestLayersAt(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("old", ""); err != nil {
+		t.Fatal(err)
+	}
+	// Forget the journal to check that seeding picks up existing layers
+	if err := os.Remove(d.journalPath()); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.seedJournal(); err != nil {
+		t.Fatal(err)
+	}
+
+	before := time.Now()
+	time.Sleep(10 * time.Millisecond)
+	if err := d.Create("new", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove("old"); err != nil {
+		t.Fatal(err)
+	}
+
+	ids, err := d.LayersAt(before)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"old"}) {
+		t.Fatalf("Expected only old before, got %v", ids)
+	}
+	ids, err = d.LayersAt(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"new"}) {
+		t.Fatalf("Expected only new now, got %v", ids)
+	}
+}
+
+func TestDump(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Annotate("1", map[string]string{"k": "v"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "diff", "1", "secret"), []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := d.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	names := map[string]bool{}
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names[hdr.Name] = true
+	}
+	for _, n := range []string{"status", "active.json", "mounts", "journal", "layers/1", "layers/2", "annotations/1"} {
+		if !names[n] {
+			t.Fatalf("Expected %s in the dump, got %v", n, names)
+		}
+	}
+	for n := range names {
+		if strings.Contains(n, "secret") {
+			t.Fatalf("Layer content should not be dumped, got %s", n)
+		}
+	}
+}
+
+func TestRemoveWithDependents(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options.verifyRemove = true
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "2"}, {"4", ""}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dependents, err := d.Dependents("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(dependents, []string{"2", "3"}) {
+		t.Fatalf("Expected 2 and 3, got %v", dependents)
+	}
+
+	err = d.Remove("1")
+	if _, ok := err.(ErrLayerInUse); !ok {
+		t.Fatalf("Expected ErrLayerInUse, got %v", err)
+	}
+	if !d.Exists("1") {
+		t.Fatal("Layer in use should not have been removed")
+	}
+	for _, id := range []string{"3", "2", "1", "4"} {
+		if err := d.Remove(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReplaceParent(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"base", ""}, {"lost", "base"}, {"child", "lost"}, {"grandchild", "child"}, {"copy", ""}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// lost goes missing, copy holds the same content as lost and base
+	if err := d.Remove("lost"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.ReplaceParent("lost", "grandchild"); err == nil {
+		t.Fatal("Expected an error replacing a layer with one built on it")
+	}
+	if _, err := d.ReplaceParent("base", "copy"); err == nil {
+		t.Fatal("Expected an error replacing a layer that still exists")
+	}
+
+	rewritten, err := d.ReplaceParent("lost", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(rewritten)
+	if !reflect.DeepEqual(rewritten, []string{"child", "grandchild"}) {
+		t.Fatalf("Expected child and grandchild to be rewritten, got %v", rewritten)
+	}
+	for id, expected := range map[string][]string{
+		"child":      {"copy"},
+		"grandchild": {"child", "copy"},
+	} {
+		parents, err := getParentIds(tmp, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(parents, expected) {
+			t.Fatalf("Expected %v as parents of %s, got %v", expected, id, parents)
+		}
+	}
+
+}
+
+func TestReplaceParentDigests(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	var content, other bytes.Buffer
+	for buf, data := range map[*bytes.Buffer]string{&content: "content", &other: "other content"} {
+		diff, err := archive.Generate("file", data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(buf, diff); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, l := range []struct {
+		id, parent string
+		diff       []byte
+	}{
+		{"lost", "", content.Bytes()},
+		{"child", "lost", nil},
+		{"different", "", other.Bytes()},
+		{"identical", "", content.Bytes()},
+	} {
+		if err := d.Create(l.id, l.parent); err != nil {
+			t.Fatal(err)
+		}
+		if l.diff == nil {
+			continue
+		}
+		if _, err := d.ApplyDiff(l.id, l.parent, bytes.NewReader(l.diff)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Records the digest of lost as it stands now
+	if err := d.Create("grandchild", "child"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove("lost"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := d.ReplaceParent("lost", "different"); err == nil {
+		t.Fatal("Expected an error replacing a layer with one of another digest")
+	}
+	if parents, err := getParentIds(tmp, "grandchild"); err != nil || !reflect.DeepEqual(parents, []string{"child", "lost"}) {
+		t.Fatalf("Expected grandchild to be left alone, got %v: %v", parents, err)
+	}
+	if _, err := d.ReplaceParent("lost", "identical"); err != nil {
+		t.Fatalf("Expected a layer of the same digest to replace lost: %v", err)
+	}
+	if parents, err := getParentIds(tmp, "grandchild"); err != nil || !reflect.DeepEqual(parents, []string{"child", "identical"}) {
+		t.Fatalf("Expected grandchild to go through identical, got %v: %v", parents, err)
+	}
+}
+
+func waitForPool(t testing.TB, p *dirPool) {
+	for i := 0; i < 1000; i++ {
+		p.Lock()
+		full := len(p.free) >= p.size && !p.refilling
+		p.Unlock()
+		if full {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatal("Timed out waiting for the directory pool to fill up")
+}
+
+func TestCreateFromPool(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	pool, err := newDirPool(tmp, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.pool = pool
+	waitForPool(t, pool)
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := d.Create(id, ""); err != nil {
+			t.Fatal(err)
+		}
+		for _, dir := range []string{"mnt", "diff"} {
+			if fi, err := os.Stat(path.Join(tmp, dir, id)); err != nil || !fi.IsDir() {
+				t.Fatalf("Expected %s/%s to be a directory: %v", dir, id, err)
+			}
+		}
+	}
+	waitForPool(t, pool)
+
+	// A new pool picks up the leftovers instead of creating more
+	pool, err = newDirPool(tmp, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForPool(t, pool)
+	entries, err := ioutil.ReadDir(path.Join(tmp, "diff"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pooled := 0
+	for _, fi := range entries {
+		if strings.HasPrefix(fi.Name(), poolPrefix) {
+			pooled++
+		}
+	}
+	if pooled != 2 {
+		t.Fatalf("Expected 2 pooled directories, got %d", pooled)
+	}
+}
+
+func benchmarkCreate(b *testing.B, poolSize int) {
+	os.RemoveAll(tmp)
+	d := newLocalDriver(b)
+	defer os.RemoveAll(tmp)
+	if poolSize > 0 {
+		pool, err := newDirPool(tmp, poolSize)
+		if err != nil {
+			b.Fatal(err)
+		}
+		d.pool = pool
+		waitForPool(b, pool)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := d.Create(fmt.Sprintf("%d", i), ""); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkCreate(b *testing.B) {
+	benchmarkCreate(b, 0)
+}
+
+func BenchmarkCreatePooled(b *testing.B) {
+	benchmarkCreate(b, 16)
+}
+
+func TestRemoveMany(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options.verifyRemove = true
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "2"}, {"4", "1"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2 and 3 go away together, 1 is still used by 4
+	errs := d.RemoveMany([]string{"1", "2", "3"})
+	if len(errs) != 1 {
+		t.Fatalf("Expected only 1 to fail, got %v", errs)
+	}
+	if _, ok := errs["1"].(ErrLayerInUse); !ok {
+		t.Fatalf("Expected ErrLayerInUse for 1, got %v", errs["1"])
+	}
+	for _, id := range []string{"2", "3"} {
+		if d.Exists(id) {
+			t.Fatalf("Expected %s to be removed", id)
+		}
+		for _, dir := range []string{"mnt", "diff"} {
+			for _, name := range []string{id, id + "-removing"} {
+				if _, err := os.Lstat(path.Join(tmp, dir, name)); !os.IsNotExist(err) {
+					t.Fatalf("Expected %s/%s to be gone, got %v", dir, name, err)
+				}
+			}
+		}
+	}
+
+	if errs := d.RemoveMany([]string{"4", "1"}); errs != nil {
+		t.Fatal(errs)
+	}
+
+	// A parent stays when its dependent in the batch cannot be removed
+	for _, l := range [][2]string{{"5", ""}, {"6", "5"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Pin("6"); err != nil {
+		t.Fatal(err)
+	}
+	errs = d.RemoveMany([]string{"5", "6"})
+	if _, ok := errs["6"].(ErrLayerPinned); !ok {
+		t.Fatalf("Expected ErrLayerPinned for 6, got %v", errs["6"])
+	}
+	if _, ok := errs["5"].(ErrLayerInUse); !ok {
+		t.Fatalf("Expected ErrLayerInUse for 5, got %v", errs["5"])
+	}
+	if !d.Exists("5") || !d.Exists("6") {
+		t.Fatal("Expected 5 and 6 to be kept")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	files := map[string][]string{
+		"1": {"usr/lib/libssl.so.1.0.0", "etc/ssl/openssl.cnf"},
+		"2": {"usr/lib/libssl.so.1.0.2", archive.WhiteoutLinkDir + "/libssl.so.1.0.0"},
+		"3": {"usr/bin/curl"},
+	}
+	for _, id := range []string{"1", "2", "3"} {
+		if err := d.Create(id, ""); err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range files[id] {
+			p := path.Join(tmp, "diff", id, f)
+			if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	found, err := d.Search("libssl.so.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"1": {"/usr/lib/libssl.so.1.0.0"},
+		"2": {"/usr/lib/libssl.so.1.0.2"},
+	}
+	if !reflect.DeepEqual(found, expected) {
+		t.Fatalf("Expected %v, got %v", expected, found)
+	}
+
+	found, err = d.Search("/etc/ssl/*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(found, map[string][]string{"1": {"/etc/ssl/openssl.cnf"}}) {
+		t.Fatalf("Unexpected whole path matches %v", found)
+	}
+
+	if _, err := d.Search("["); err == nil {
+		t.Fatal("Expected an error for a malformed pattern")
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "2"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files := map[string][]string{
+		"1": {"etc/passwd", "etc/hosts", "opt/app/old", "var/log/base"},
+		"2": {"etc/hosts", "opt/app/" + archive.WhiteoutOpaqueDir, "opt/app/new", "var/" + archive.WhiteoutPrefix + "log"},
+		"3": {"etc/" + archive.WhiteoutPrefix + "passwd"},
+	}
+	for id, fs := range files {
+		for _, f := range fs {
+			p := path.Join(tmp, "diff", id, f)
+			if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := ioutil.WriteFile(p, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	links := map[string][2]string{
+		// Resolved in the union, whatever layer the link comes from
+		"lib":  {"1", "opt/app"},
+		"abs":  {"3", "/opt"},
+		"host": {"3", "/etc"},
+		"loop": {"3", "loop"},
+	}
+	for name, l := range links {
+		if err := os.Symlink(l[1], path.Join(tmp, "diff", l[0], name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for p, expected := range map[string]string{
+		"/etc/hosts":   "2",
+		"/opt/app/new": "2",
+		"/etc":         "3",
+		"/lib/new":     "2",
+		"/abs/app/new": "2",
+		"/lib":         "1",
+		"/abs/../lib":  "1",
+	} {
+		layer, err := d.Provenance("3", p)
+		if err != nil {
+			t.Fatalf("%s: %v", p, err)
+		}
+		if layer != expected {
+			t.Fatalf("Expected %s to come from %s, got %s", p, expected, layer)
+		}
+	}
+	for _, p := range []string{"/etc/passwd", "/opt/app/old", "/var/log/base", "/missing", "/lib/old", "/host/passwd", "/etc/hosts/x"} {
+		if _, err := d.Provenance("3", p); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s not to be visible, got %v", p, err)
+		}
+	}
+	if _, err := d.Provenance("3", "/loop/x"); err == nil || os.IsNotExist(err) {
+		t.Fatalf("Expected a loop of links to fail, got %v", err)
+	}
+	if layer, err := d.Provenance("1", "/etc/passwd"); err != nil || layer != "1" {
+		t.Fatalf("Expected /etc/passwd to be visible from 1, got %s: %v", layer, err)
+	}
+}
+
+func TestMountBudget(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options.maxBranches = 4
+	d.options.maxMounts = 2
+
+	if err := d.checkMountBudget("1", 4); err != nil {
+		t.Fatal(err)
+	}
+	err := d.checkMountBudget("1", 5)
+	if e, ok := err.(ErrMountBudget); !ok || e.What != "branch" {
+		t.Fatalf("Expected the branch limit to be hit, got %v", err)
+	}
+
+	d.mountedBranches["a"] = 2
+	d.mountedBranches["b"] = 3
+	err = d.checkMountBudget("c", 2)
+	if e, ok := err.(ErrMountBudget); !ok || e.What != "mount" {
+		t.Fatalf("Expected the mount limit to be hit, got %v", err)
+	}
+	d.updateUsage()
+	if u := d.currentUsage(); u.mounts != 2 || u.branches != 5 {
+		t.Fatalf("Expected 2 mounts using 5 branches, got %d and %d", u.mounts, u.branches)
+	}
+}
+
+func TestBindReadOnly(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "diff", "1", "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	target := path.Join(tmpOuter, "export")
+	defer os.RemoveAll(target)
+	if err := d.BindReadOnly("1", target); err != nil {
+		if strings.Contains(err.Error(), "operation not permitted") {
+			t.Skip(err)
+		}
+		t.Fatal(err)
+	}
+	defer mountpk.Unmount(target)
+
+	content, err := ioutil.ReadFile(path.Join(target, "file"))
+	if err != nil || string(content) != "content" {
+		t.Fatalf("Expected to read the layer through the export, got %q: %v", content, err)
+	}
+	if err := ioutil.WriteFile(path.Join(target, "other"), nil, 0644); err == nil {
+		t.Fatal("Export should be read-only")
+	}
+	if err := d.BindReadOnly("1", target); err == nil {
+		t.Fatal("Expected an error exporting twice at the same target")
+	}
+	if err := d.Remove("1"); err == nil {
+		t.Fatal("Expected an error removing an exported layer")
+	}
+
+	// The exports still mounted survive a restart
+	d.bindExports = make(map[string]string)
+	if err := d.loadExports(); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove("1"); err == nil {
+		t.Fatal("Expected an error removing a layer exported before a restart")
+	}
+
+	other := path.Join(tmpOuter, "other")
+	defer os.RemoveAll(other)
+	if err := os.MkdirAll(other, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := mountpk.Mount(tmpOuter, other, "none", "bind,ro"); err != nil {
+		t.Fatal(err)
+	}
+	defer mountpk.Unmount(other)
+	if err := d.BindReadOnly("1", other); err == nil {
+		t.Fatal("Expected an error exporting over a mountpoint")
+	}
+
+	// Cleanup releases all the exports
+	d.Lock()
+	err = d.unbindAll()
+	d.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mounted, err := mountpk.Mounted(target); err != nil || mounted {
+		t.Fatalf("Expected the export to be released: %v", err)
+	}
+	if _, err := os.Lstat(d.exportsPath()); !os.IsNotExist(err) {
+		t.Fatalf("Expected no exports to be recorded: %v", err)
+	}
+
+	if err := d.BindReadOnly("1", target); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.UnbindReadOnly(target); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove("1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLayersFileChecksum(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	p := path.Join(tmp, "layers", "2")
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(content), "1\n"+checksumPrefix) {
+		t.Fatalf("Expected the chain followed by its checksum, got %q", content)
+	}
+
+	// A damaged chain must not be handed out
+	if err := ioutil.WriteFile(p, append([]byte("3"), content[1:]...), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := getParentIds(tmp, "2"); err == nil {
+		t.Fatal("Expected a checksum error")
+	}
+
+	// Files written by older versions have no checksum
+	if err := ioutil.WriteFile(p, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ids, err := getParentIds(tmp, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("Expected [1], got %v", ids)
+	}
+}
+
+func TestRebuildMetadata(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "2"}, {"4", ""}, {"5", "4"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, id := range []string{"2", "3", "4", "5"} {
+		if err := os.Remove(path.Join(tmp, "layers", id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Nothing is known about 4, so 5 cannot be placed either
+	rebuilt, unresolved, err := d.RebuildMetadata(map[string]string{"3": "2", "2": "1", "5": "4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(rebuilt)
+	sort.Strings(unresolved)
+	if !reflect.DeepEqual(rebuilt, []string{"2", "3"}) {
+		t.Fatalf("Expected 2 and 3 to be rebuilt, got %v", rebuilt)
+	}
+	if !reflect.DeepEqual(unresolved, []string{"4", "5"}) {
+		t.Fatalf("Expected 4 and 5 to be unresolved, got %v", unresolved)
+	}
+	ids, err := getParentIds(tmp, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"2", "1"}) {
+		t.Fatalf("Expected [2 1], got %v", ids)
+	}
+}
+
+func TestParentHints(t *testing.T) {
+	pth, err := ioutil.TempDir("", "docker-aufs-hints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(pth)
+
+	files := map[string]string{
+		"graph/base/json":            `{"id":"base"}`,
+		"graph/child/json":           `{"id":"child","parent":"base"}`,
+		"containers/c1/config.json":  `{"ID":"c1","Image":"child"}`,
+		"containers/bad/config.json": `{`,
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(path.Dir(path.Join(pth, name)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(pth, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	hints, err := ParentHints(pth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"base": "", "child": "base", "c1-init": "child", "c1": "c1-init"}
+	if !reflect.DeepEqual(hints, expected) {
+		t.Fatalf("Expected %v, got %v", expected, hints)
+	}
+}
+
+func TestValidateChain(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "2"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if problems := d.ValidateChain("3"); problems != nil {
+		t.Fatalf("Expected no problems, got %v", problems)
+	}
+	if problems := d.ValidateChain("4"); len(problems) != 1 {
+		t.Fatalf("Expected an unknown layer to be reported, got %v", problems)
+	}
+
+	if err := os.RemoveAll(path.Join(tmp, "diff", "1")); err != nil {
+		t.Fatal(err)
+	}
+	d.options.maxBranches = 2
+	problems := d.ValidateChain("3")
+	if len(problems) != 2 {
+		t.Fatalf("Expected the missing branch and the branch limit to be reported, got %v", problems)
+	}
+	if !os.IsNotExist(problems[1]) {
+		t.Fatalf("Expected a missing branch error, got %v", problems[1])
+	}
+}
+
+func TestPlacement(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	placement, err := d.Placement("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if placement.Path != path.Join(tmp, "diff", "1") {
+		t.Fatalf("Unexpected path %s", placement.Path)
+	}
+	if placement.Mountpoint == "" || !isUnder(placement.Path, placement.Mountpoint) {
+		t.Fatalf("Expected the filesystem of %s to be found, got %+v", placement.Path, placement)
+	}
+	if _, err := d.Placement("2"); err == nil {
+		t.Fatal("Expected an error for an unknown layer")
+	}
+}
+
+func TestStructuredStatus(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Put("1")
+
+	b, err := json.Marshal(d.StructuredStatus())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info StatusInfo
+	if err := json.Unmarshal(b, &info); err != nil {
+		t.Fatal(err)
+	}
+	if info.RootDir != tmp || info.Dirs != 1 || info.ActiveLayers != 1 || info.DirPool != nil {
+		t.Fatalf("Unexpected status %s", b)
+	}
+	if c := info.Capabilities; c.APIVersion != driverAPIVersion || c.Limits.MaxBranches != defaultMaxBranches || len(c.Operations) == 0 {
+		t.Fatalf("Unexpected capabilities %+v", c)
+	}
+	if status := d.Status(); status[2][1] != "1" {
+		t.Fatalf("Expected Status to report 1 dir, got %v", status)
+	}
+}
+
+func TestOperations(t *testing.T) {
+	// The operation of each exported method, "" for the ones of the
+	// graphdriver interfaces and the ones reporting on the driver itself
+	methods := map[string]string{
+		"Adopt":              "adopt",
+		"Annotate":           "annotations",
+		"Annotations":        "annotations",
+		"ApproximateChanges": "approximate-changes",
+		"BindReadOnly":       "bind-readonly",
+		"Capabilities":       "",
+		"ChainSize":          "chain-size",
+		"CheckRemove":        "check-remove",
+		"Dependents":         "dependents",
+		"Dump":               "dump",
+		"ExportDiff":         "export-diff",
+		"ExportOCILayout":    "oci-layout",
+		"FindByAnnotation":   "annotations",
+		"ImportOCILayout":    "oci-layout",
+		"ImportStaged":       "import-staged",
+		"ImportedLayers":     "import-staged",
+		"Inventory":          "inventory",
+		"LastAccess":         "access-times",
+		"LayerInfos":         "inventory",
+		"LayersAt":           "journal",
+		"ListLayers":         "list-layers",
+		"LogicalSize":        "logical-size",
+		"Migrate":            "",
+		"MigrateLayout":      "layout-migration",
+		"MountStats":         "mount-stats",
+		"NotifyImported":     "import-staged",
+		"Pin":                "pin",
+		"Placement":          "placement",
+		"Provenance":         "provenance",
+		"RebuildMetadata":    "rebuild-metadata",
+		"Refresh":            "refresh",
+		"ReleaseImported":    "import-staged",
+		"RemoveDryRun":       "remove-dry-run",
+		"RemoveMany":         "remove-many",
+		"ReplaceParent":      "replace-parent",
+		"Retire":             "retire",
+		"Scavenge":           "scavenge",
+		"Search":             "search",
+		"SelfTest":           "selftest",
+		"StructuredStatus":   "",
+		"UnbindReadOnly":     "bind-readonly",
+		"Unpin":              "pin",
+		"Unretire":           "retire",
+		"ValidateChain":      "validate-chain",
+		"VerifyLayers":       "verify-layers",
+		"WriteInventory":     "inventory",
+	}
+	listed := make(map[string]bool)
+	for _, op := range operations {
+		listed[op] = true
+	}
+	used := make(map[string]bool)
+
+	driverType := reflect.TypeOf((*graphdriver.Driver)(nil)).Elem()
+	mutexType := reflect.TypeOf(&sync.Mutex{})
+	typ := reflect.TypeOf(&Driver{})
+	for i := 0; i < typ.NumMethod(); i++ {
+		name := typ.Method(i).Name
+		if _, ok := driverType.MethodByName(name); ok {
+			continue
+		}
+		if _, ok := mutexType.MethodByName(name); ok {
+			continue
+		}
+		op, ok := methods[name]
+		if !ok {
+			t.Fatalf("Method %s is not known to be an operation", name)
+		}
+		if op != "" && !listed[op] {
+			t.Fatalf("Operation %s of method %s is not listed", op, name)
+		}
+		used[op] = true
+	}
+	for _, op := range operations {
+		if !used[op] {
+			t.Fatalf("Operation %s is listed but has no method", op)
+		}
+	}
+}
+
+func TestListLayers(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, id := range []string{"b2", "a1", "b1", "c1", "b3"} {
+		if err := d.Create(id, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var pages [][]string
+	after := ""
+	for {
+		page, err := d.ListLayers("b", after, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		pages = append(pages, page)
+		after = page[len(page)-1]
+	}
+	expected := [][]string{{"b1", "b2"}, {"b3"}}
+	if !reflect.DeepEqual(pages, expected) {
+		t.Fatalf("Expected %v, got %v", expected, pages)
+	}
+
+	all, err := d.ListLayers("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(all, []string{"a1", "b1", "b2", "b3", "c1"}) {
+		t.Fatalf("Unexpected layers %v", all)
+	}
+
+	var seen int
+	if err := walkIds(path.Join(tmp, "layers"), "", func(string) error {
+		seen++
+		return errStopWalk
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if seen != 1 {
+		t.Fatalf("Expected the walk to stop after the first id, got %d", seen)
+	}
+}
+
+func TestParentIdsCache(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", ""}, {"3", "1"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids, err := getParentIds(tmp, "3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Callers get their own copy of the memoized chain
+	ids[0] = "changed"
+	if ids, _ := getParentIds(tmp, "3"); !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("Expected [1], got %v", ids)
+	}
+
+	// A rewritten chain of the same size must not be served from the cache
+	if err := d.writeParentIds("3", []string{"2"}); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _ := getParentIds(tmp, "3"); !reflect.DeepEqual(ids, []string{"2"}) {
+		t.Fatalf("Expected [2], got %v", ids)
+	}
+
+	if err := d.Remove("3"); err != nil {
+		t.Fatal(err)
+	}
+	chainCache.Lock()
+	_, cached := chainCache.m[path.Join(tmp, "layers", "3")]
+	chainCache.Unlock()
+	if cached {
+		t.Fatal("Expected the chain of a removed layer to be forgotten")
+	}
+}
+
+func TestChainDigestsAndSizes(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	diff, err := archive.Generate("file", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := d.ApplyDiff("1", "", diff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, parents, err := readChain(tmp, "2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(parents) != 1 || parents[0].id != "1" || !strings.HasPrefix(parents[0].digest, "sha256:") || parents[0].size != size {
+		t.Fatalf("Expected the digest and size of 1 to be recorded, got %+v", parents)
+	}
+	if ids, err := getParentIds(tmp, "2"); err != nil || !reflect.DeepEqual(ids, []string{"1"}) {
+		t.Fatalf("Expected [1], got %v: %v", ids, err)
+	}
+
+	// Nothing was applied to 2, so its size is unknown
+	if _, err := d.ChainSize("2"); err == nil {
+		t.Fatal("Expected an error for an unknown size")
+	}
+	if total, err := d.ChainSize("1"); err != nil || total != size {
+		t.Fatalf("Expected a size of %d, got %d: %v", size, total, err)
+	}
+
+	if problems := d.ValidateChain("2"); problems != nil {
+		t.Fatalf("Expected no problems, got %v", problems)
+	}
+	diff, err = archive.Generate("file", "other content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ApplyDiff("1", "", diff); err != nil {
+		t.Fatal(err)
+	}
+	if problems := d.ValidateChain("2"); len(problems) != 1 {
+		t.Fatalf("Expected the digest mismatch of 1 to be reported, got %v", problems)
+	}
+}
+
+func TestSelfTest(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	results := d.SelfTest()
+	if len(results) != 6 {
+		t.Fatalf("Expected 6 checks, got %+v", results)
+	}
+	aufsSupported := results[0].Passed
+	for _, r := range results {
+		if r.Name == "rename on root" && !r.Passed {
+			t.Fatalf("Expected renames to work on the test root: %s", r.Detail)
+		}
+		if r.Skipped && aufsSupported {
+			t.Fatalf("Check %s should not be skipped when aufs is supported", r.Name)
+		}
+	}
+
+	fis, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		if strings.HasPrefix(fi.Name(), ".selftest-") {
+			t.Fatalf("Self test left %s behind", fi.Name())
+		}
+	}
+}
+
+func TestMountWatchdog(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options.mountTimeout = 10 * time.Millisecond
+
+	release := make(chan struct{})
+	hung := func() error {
+		<-release
+		return nil
+	}
+
+	d.Lock()
+	err := d.watchdog("1", "mount", hung)
+	d.Unlock()
+	if _, ok := err.(ErrMountHung); !ok {
+		t.Fatalf("Expected ErrMountHung, got %v", err)
+	}
+
+	// The layer is poisoned until the hung operation returns
+	d.Lock()
+	err = d.watchdog("1", "unmount", func() error { return nil })
+	d.Unlock()
+	if _, ok := err.(ErrMountHung); !ok {
+		t.Fatalf("Expected the poisoned layer to be refused, got %v", err)
+	}
+	d.Lock()
+	err = d.watchdog("2", "mount", func() error { return nil })
+	d.Unlock()
+	if err != nil {
+		t.Fatalf("Other layers should not be affected: %v", err)
+	}
+
+	close(release)
+	for i := 0; ; i++ {
+		d.Lock()
+		poisoned := d.poisoned["1"]
+		d.Unlock()
+		if !poisoned {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Expected the layer to be usable once the operation completed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestShardedLayout(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	// Layers created before the switch stay where they are
+	if err := d.Create("aaa1", ""); err != nil {
+		t.Fatal(err)
+	}
+	d.options.sharded = true
+	if err := d.Create("bbb2", "aaa1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{"diff/aaa1", "layers/aaa1", "diff/bb/bbb2", "mnt/bb/bbb2", "layers/bb/bbb2", "layers/1"} {
+		if _, err := os.Lstat(path.Join(tmp, p)); err != nil {
+			t.Fatalf("Expected %s to exist: %v", p, err)
+		}
+	}
+
+	ids, err := d.ListLayers("", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ids, []string{"1", "aaa1", "bbb2"}) {
+		t.Fatalf("Expected layers of both layouts to be listed, got %v", ids)
+	}
+	if ids, err := getParentIds(tmp, "bbb2"); err != nil || !reflect.DeepEqual(ids, []string{"aaa1"}) {
+		t.Fatalf("Expected [aaa1], got %v: %v", ids, err)
+	}
+	if !d.layoutMismatch() {
+		t.Fatal("Expected aaa1 to be reported as in the wrong layout")
+	}
+
+	// A layer in use by Diff is left alone until the archive is closed
+	arch, err := d.Diff("aaa1", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := d.MigrateLayout(true); err != nil || moved != 0 {
+		t.Fatalf("Expected the layer in use to stay, got %d moved: %v", moved, err)
+	}
+	arch.Close()
+
+	moved, err := d.MigrateLayout(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 1 {
+		t.Fatalf("Expected aaa1 to be moved, moved %d layers", moved)
+	}
+	if _, err := os.Lstat(path.Join(tmp, "diff", "aa", "aaa1")); err != nil {
+		t.Fatal(err)
+	}
+	if d.layoutMismatch() {
+		t.Fatal("Expected every layer to be in the sharded layout")
+	}
+	if problems := d.ValidateChain("bbb2"); problems != nil {
+		t.Fatalf("Expected no problems after the migration, got %v", problems)
+	}
+
+	if moved, err := d.MigrateLayout(false); err != nil || moved != 2 {
+		t.Fatalf("Expected 2 layers to be moved back, got %d: %v", moved, err)
+	}
+	d.options.sharded = false
+	if d.layoutMismatch() {
+		t.Fatal("Expected the emptied shards to be removed")
+	}
+	if err := d.Remove("bbb2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(path.Join(tmp, "diff", "bbb2")); !os.IsNotExist(err) {
+		t.Fatalf("Expected bbb2 to be removed: %v", err)
+	}
+}
+
+func TestImportStaged(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	var (
+		base    = strings.Repeat("b", 64)
+		child   = strings.Repeat("c", 64)
+		orphan  = strings.Repeat("d", 64)
+		invalid = strings.Repeat("e", 64)
+		partial = strings.Repeat("f", 64)
+		other   = strings.Repeat("a", 64)
+	)
+	staging := path.Join(tmp, stagingDir)
+	baseImage := `{"id":"` + base + `","comment":"pre-baked"}`
+	files := map[string]string{
+		child + "/file":              "child",
+		child + ".parent":            base + "\n",
+		base + "/file":               "base",
+		base + ".json":               baseImage,
+		other + "/file":              "other",
+		other + ".json":              `{"id":"` + base + `"}`,
+		"." + partial + "/file":      "incomplete",
+		orphan + "/file":             "orphan",
+		orphan + ".parent":           strings.Repeat("0", 64),
+		"with space/file":            "misnamed",
+		"a:b=rw/file":                "misnamed",
+		strings.ToUpper(base) + "/f": "misnamed",
+	}
+	for name, content := range files {
+		p := path.Join(staging, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// aufs doesn't understand whiteout directories
+	if err := os.MkdirAll(path.Join(staging, invalid, archive.WhiteoutPrefix+"dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	notified := 0
+	d.NotifyImported(func() { notified++ })
+	adopted, err := d.ImportStaged()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(adopted, []string{base, child}) {
+		t.Fatalf("Expected base and child to be imported, got %v", adopted)
+	}
+	if ids, err := getParentIds(tmp, child); err != nil || !reflect.DeepEqual(ids, []string{base}) {
+		t.Fatalf("Expected child to be on base, got %v: %v", ids, err)
+	}
+	content, err := ioutil.ReadFile(path.Join(tmp, "diff", child, "file"))
+	if err != nil || string(content) != "child" {
+		t.Fatalf("Expected the staged content, got %q: %v", content, err)
+	}
+	for _, left := range []string{"." + partial, orphan, orphan + ".parent", invalid, "with space", "a:b=rw", strings.ToUpper(base), other, other + ".json"} {
+		if _, err := os.Lstat(path.Join(staging, left)); err != nil {
+			t.Fatalf("Expected %s to be left in staging: %v", left, err)
+		}
+	}
+	if _, err := os.Lstat(path.Join(staging, child+".parent")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the parent file of an imported layer to be removed: %v", err)
+	}
+
+	// The adopted layers are kept, parents first, until they are released
+	if notified != 1 {
+		t.Fatalf("Expected the import to be notified once, got %d", notified)
+	}
+	imported, err := d.ImportedLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imported) != 2 || imported[0].ID != base || string(imported[0].Image) != baseImage || imported[0].Size != 4 ||
+		imported[1].ID != child || imported[1].Parent != base || imported[1].Image != nil {
+		t.Fatalf("Expected base with its image json and child on base, got %+v", imported)
+	}
+	if err := d.ReleaseImported(base); err != nil {
+		t.Fatal(err)
+	}
+	if imported, err := d.ImportedLayers(); err != nil || len(imported) != 1 || imported[0].ID != child {
+		t.Fatalf("Expected only child to be left, got %+v: %v", imported, err)
+	}
+}
+
+func TestParseOptionsProfile(t *testing.T) {
+	opts, err := parseOptions([]string{"aufs.durability=none", "aufs.profile=Edge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.profile != "edge" || !opts.verifyRemove || opts.mountTimeout != 2*time.Minute {
+		t.Fatalf("Expected the edge profile to be applied, got %+v", opts)
+	}
+	// Explicit options win over the profile, wherever they are given
+	if opts.durability != durabilityNone {
+		t.Fatalf("Expected the explicit durability, got %s", opts.durability)
+	}
+
+	// Status shows what the options resolved to, override included
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options = opts
+	status := make(map[string]string)
+	for _, kv := range d.Status() {
+		status[kv[0]] = kv[1]
+	}
+	for key, expected := range map[string]string{
+		"Profile":            "edge",
+		"Durability":         "none",
+		"Mount Idle Timeout": "10m0s",
+		"Mount Timeout":      "2m0s",
+		"Verify Remove":      "true",
+		"Create Pool":        "0",
+		"Whiteouts":          "aufs",
+	} {
+		if status[key] != expected {
+			t.Fatalf("Expected %s to be %s, got %q", key, expected, status[key])
+		}
+	}
+
+	for name := range profiles {
+		if _, err := parseOptions([]string{"aufs.profile=" + name}); err != nil {
+			t.Fatalf("Profile %s does not parse: %v", name, err)
+		}
+	}
+	for _, invalid := range [][]string{
+		{"aufs.profile=laptop"},
+		{"aufs.profile=ci", "aufs.profile=edge"},
+	} {
+		if _, err := parseOptions(invalid); err == nil {
+			t.Fatalf("Expected an error parsing %v", invalid)
+		}
+	}
+}
+
+func TestLogicalSize(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	diff := path.Join(tmp, "diff", "1")
+	files := map[string]int{
+		"data":                              100,
+		"dir/more":                          50,
+		".wh.deleted":                       0,
+		"dir/" + archive.WhiteoutOpaqueDir:  0,
+		archive.WhiteoutLinkDir + "/copied": 1000,
+		archive.WhiteoutMetaPrefix + "orph/leftover": 10,
+	}
+	for name, size := range files {
+		p := path.Join(diff, name)
+		if err := os.MkdirAll(path.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Link(path.Join(diff, "data"), path.Join(diff, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	size, err := d.LogicalSize("1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := LayerSize{Raw: 1160, Logical: 150, Whiteouts: 2}
+	if *size != expected {
+		t.Fatalf("Expected %+v, got %+v", expected, *size)
+	}
+}
+
+func TestRetire(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Retire("1", "base:v2"); err != nil {
+		t.Fatal(err)
+	}
+
+	err := d.Create("3", "2")
+	retired, ok := err.(ErrLayerRetired)
+	if !ok {
+		t.Fatalf("Expected ErrLayerRetired, got %v", err)
+	}
+	if retired.Retired != "1" || retired.Replacement != "base:v2" {
+		t.Fatalf("Unexpected error %+v", retired)
+	}
+	if d.Exists("3") {
+		t.Fatal("The refused layer should not exist")
+	}
+	if err := d.Unretire("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("3", "2"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMetricsFile(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options.metricsFile = path.Join(tmp, "aufs.prom")
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.writeMetricsFile(time.Unix(1000, 0)); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(d.options.metricsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"# TYPE docker_aufs_layers gauge",
+		"docker_aufs_layers 2",
+		"docker_aufs_mounts 0",
+		"docker_aufs_metrics_timestamp_seconds 1000",
+		`layout="flat"`,
+	} {
+		if !strings.Contains(string(b), line) {
+			t.Fatalf("Expected %q in the metrics, got:\n%s", line, b)
+		}
+	}
+
+	// No temporary file is left behind
+	entries, err := ioutil.ReadDir(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".aufs.prom") {
+			t.Fatalf("Temporary file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestAccessTimes(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.LastAccess("1"); ok {
+		t.Fatal("Expected no access time before the first use")
+	}
+
+	start := time.Unix(1000, 0)
+	d.Lock()
+	d.touch([]string{"2", "1"}, start)
+	// Within the granularity nothing changes
+	d.accessDirty = false
+	d.touch([]string{"2", "1"}, start.Add(time.Minute))
+	dirty := d.accessDirty
+	d.Unlock()
+	if dirty {
+		t.Fatal("Expected no rewrite within the access granularity")
+	}
+	if last, ok := d.LastAccess("1"); !ok || !last.Equal(start) {
+		t.Fatalf("Expected the parent to be used at %s, got %s", start, last)
+	}
+
+	d.Lock()
+	d.touch([]string{"2"}, start.Add(accessGranularity))
+	d.Unlock()
+	if err := d.flushAccessTimes(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The times survive a restart
+	d2 := newLocalDriver(t)
+	if err := d2.loadAccessTimes(); err != nil {
+		t.Fatal(err)
+	}
+	if last, ok := d2.LastAccess("2"); !ok || !last.Equal(start.Add(accessGranularity)) {
+		t.Fatalf("Expected the layer to be used at %s, got %s", start.Add(accessGranularity), last)
+	}
+
+	if err := d2.Remove("2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d2.LastAccess("2"); ok {
+		t.Fatal("Expected the access time to go with the layer")
+	}
+}
+
+func TestScavenge(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	leftovers := []string{
+		"diff/2-removing",
+		"mnt/2-removing",
+		"diff/ab/abcdef-removing",
+		"diff/orphan",
+		"mnt/orphan",
+	}
+	kept := []string{
+		"diff/1",
+		"mnt/1",
+		"diff/" + poolPrefix + "0123",
+	}
+	for _, p := range append(append([]string{}, leftovers...), kept...) {
+		if err := os.MkdirAll(path.Join(tmp, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(p, "diff/") {
+			if err := ioutil.WriteFile(path.Join(tmp, p, "file"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Orphans are only deleted on request
+	removed, err := d.Scavenge(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != len(leftovers)-2 {
+		t.Fatalf("Expected only the leftovers of removals to be removed, got %v", removed)
+	}
+	for _, p := range []string{"diff/orphan", "mnt/orphan"} {
+		if _, err := os.Stat(path.Join(tmp, p)); err != nil {
+			t.Fatalf("Expected %s to be kept: %v", p, err)
+		}
+	}
+	for _, p := range leftovers[:3] {
+		if err := os.MkdirAll(path.Join(tmp, p), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err = d.Scavenge(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != len(leftovers) {
+		t.Fatalf("Expected %d leftovers to be removed, got %v", len(leftovers), removed)
+	}
+	for _, p := range leftovers {
+		if _, err := os.Stat(path.Join(tmp, p)); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s to be removed", p)
+		}
+	}
+	for _, p := range kept {
+		if _, err := os.Stat(path.Join(tmp, p)); err != nil {
+			t.Fatalf("Expected %s to be kept: %v", p, err)
+		}
+	}
+
+	// Without any metadata the directories are kept for RebuildMetadata
+	if err := os.Remove(path.Join(tmp, "layers", "1")); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := d.Scavenge(true); err != nil || len(removed) != 0 {
+		t.Fatalf("Expected nothing to be removed, got %v (%v)", removed, err)
+	}
+}
+
+func TestSysfsStats(t *testing.T) {
+	defer os.RemoveAll(tmp)
+	defer func(sysfs, debugfs string) {
+		aufsSysfs, aufsDebugfs = sysfs, debugfs
+	}(aufsSysfs, aufsDebugfs)
+	aufsSysfs, aufsDebugfs = path.Join(tmp, "sysfs"), path.Join(tmp, "debugfs")
+
+	if si := mountSI("rw,relatime,si=5e1f2a3b,dio,dirperm1"); si != "5e1f2a3b" {
+		t.Fatalf("Expected si 5e1f2a3b, got %q", si)
+	}
+	files := map[string]string{
+		"sysfs/si_5e1f2a3b/br0":      "/var/lib/docker/aufs/diff/2=rw\n",
+		"sysfs/si_5e1f2a3b/br1":      "/var/lib/docker/aufs/diff/1=ro+wh\n",
+		"sysfs/si_5e1f2a3b/br10":     "/var/lib/docker/aufs/diff/0=ro+wh\n",
+		"sysfs/si_5e1f2a3b/brid0":    "64\n",
+		"sysfs/si_5e1f2a3b/xi_path":  "/dev/shm/aufs.xino\n",
+		"debugfs/si_5e1f2a3b/plinks": "1\n0 \n3 0\n",
+	}
+	for p, content := range files {
+		if err := os.MkdirAll(path.Join(tmp, path.Dir(p)), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(tmp, p), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats, err := readSysfsStats("5e1f2a3b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"/var/lib/docker/aufs/diff/2=rw",
+		"/var/lib/docker/aufs/diff/1=ro+wh",
+		"/var/lib/docker/aufs/diff/0=ro+wh",
+	}
+	if !reflect.DeepEqual(stats.Branches, expected) {
+		t.Fatalf("Expected branches %v, got %v", expected, stats.Branches)
+	}
+	if stats.XinoPath != "/dev/shm/aufs.xino" || stats.Xino != "" || stats.Plinks != 3 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	var buf bytes.Buffer
+	writeMetrics(&buf, &StatusInfo{}, map[string]*SysfsStats{"2": stats}, time.Unix(0, 0))
+	for _, line := range []string{
+		`docker_aufs_mount_branches{id="2",si="5e1f2a3b"} 3`,
+		`docker_aufs_mount_plinks{id="2",si="5e1f2a3b"} 3`,
+	} {
+		if !strings.Contains(buf.String(), line) {
+			t.Fatalf("Expected %q in the metrics, got:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, id := range []string{"1", "2"} {
+		if err := d.Create(id, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Creating an existing layer again does not count it twice
+	if err := d.Create("2", ""); err != nil {
+		t.Fatal(err)
+	}
+	if info := d.statusInfo(); info.Dirs != 2 || !info.DirsCountedAt.IsZero() {
+		t.Fatalf("Expected 2 uncounted dirs, got %d counted at %s", info.Dirs, info.DirsCountedAt)
+	}
+
+	// Layers added behind the back of the driver show up after a refresh
+	if err := ioutil.WriteFile(path.Join(tmp, "layers", "3"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if info := d.statusInfo(); info.Dirs != 2 {
+		t.Fatalf("Expected 2 dirs before the refresh, got %d", info.Dirs)
+	}
+	if n, err := d.Refresh(); err != nil || n != 3 {
+		t.Fatalf("Expected 3 dirs, got %d (%v)", n, err)
+	}
+	if err := d.Remove("1"); err != nil {
+		t.Fatal(err)
+	}
+	if info := d.statusInfo(); info.Dirs != 2 || info.DirsCountedAt.IsZero() {
+		t.Fatalf("Expected 2 dirs counted at refresh time, got %d counted at %s", info.Dirs, info.DirsCountedAt)
+	}
+}
+
+func TestPin(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, id := range []string{"1", "2"} {
+		if err := d.Create(id, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := d.Pin(id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Remove("1"); err == nil {
+		t.Fatal("Expected an error removing a pinned layer")
+	} else if _, ok := err.(ErrLayerPinned); !ok {
+		t.Fatalf("Expected ErrLayerPinned, got %v", err)
+	}
+	if errs := d.RemoveMany([]string{"2"}); errs["2"] == nil {
+		t.Fatal("Expected an error removing a pinned layer in a batch")
+	}
+	if !d.Exists("1") || !d.Exists("2") {
+		t.Fatal("Expected the pinned layers to be kept")
+	}
+	if r, err := d.RemoveDryRun("1"); err != nil || !r.Pinned {
+		t.Fatalf("Expected the dry run to report the pin, got %+v (%v)", r, err)
+	}
+
+	if err := d.Unpin("1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Remove("1"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestApproximateChanges(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	for p, id := range map[string]string{"a": "1", "b": "1"} {
+		if err := ioutil.WriteFile(path.Join(tmp, "diff", id, p), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Layer 2 modifies a and deletes b
+	for _, p := range []string{"a", ".wh.b"} {
+		if err := ioutil.WriteFile(path.Join(tmp, "diff", "2", p), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	changes, err := d.ApproximateChanges("2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []archive.Change{
+		{Path: "/b", Kind: archive.ChangeDelete},
+		{Path: "/a", Kind: archive.ChangeAdd},
+	}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("Expected %v, got %v", expected, changes)
+	}
+}
+
+func TestVerifyLayers(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "2"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Remove(path.Join(tmp, "mnt", "2")); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		checked int
+		failed  []string
+		after   string
+	)
+	for {
+		report, err := d.VerifyLayers(after, 2, time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checked += report.Checked
+		for id := range report.Failed {
+			failed = append(failed, id)
+		}
+		if report.Next == "" {
+			break
+		}
+		after = report.Next
+	}
+	if checked != 3 || !reflect.DeepEqual(failed, []string{"2"}) {
+		t.Fatalf("Expected 3 layers checked and 2 failed, got %d checked and %v failed", checked, failed)
+	}
+
+	// The content of an applied layer is checked against its digest
+	diff, err := archive.Generate("file", "content")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.ApplyDiff("3", "2", diff); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := d.VerifyLayers("2", 0, 0); err != nil || len(report.Failed) != 0 {
+		t.Fatalf("Expected the content of 3 to match, got %+v: %v", report, err)
+	}
+	if err := ioutil.WriteFile(path.Join(tmp, "diff", "3", "file"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	report, err := d.VerifyLayers("2", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Failed["3"]) != 1 || !strings.Contains(report.Failed["3"][0], "was recorded") {
+		t.Fatalf("Expected the changed content of 3 to be reported, got %+v", report)
+	}
+}
+
+func TestInventory(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	for _, l := range [][2]string{{"1", ""}, {"2", "1"}, {"3", "1"}} {
+		if err := d.Create(l[0], l[1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	used := time.Unix(1000, 0)
+	d.Lock()
+	d.touch([]string{"2", "1"}, used)
+	d.Unlock()
+
+	var buf bytes.Buffer
+	if err := d.WriteInventory(&buf, "json"); err != nil {
+		t.Fatal(err)
+	}
+	var entries []InventoryEntry
+	if err := json.Unmarshal(buf.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("Expected 3 layers, got %d", len(entries))
+	}
+	if e := entries[0]; e.ID != "1" || !reflect.DeepEqual(e.Children, []string{"2", "3"}) || e.LastUsed == nil || !e.LastUsed.Equal(used) {
+		t.Fatalf("Unexpected entry %+v", e)
+	}
+	if e := entries[2]; e.ID != "3" || !reflect.DeepEqual(e.Parents, []string{"1"}) || e.LastUsed != nil || e.Size != -1 {
+		t.Fatalf("Unexpected entry %+v", e)
+	}
+
+	buf.Reset()
+	if err := d.WriteInventory(&buf, "csv"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 || lines[0] != "id,digest,size,location,parents,children,active,last_used" {
+		t.Fatalf("Unexpected CSV inventory:\n%s", buf.String())
+	}
+	if expected := fmt.Sprintf("1,,-1,%s,,2 3,0,1970-01-01T00:16:40Z", path.Join(tmp, "diff", "1")); lines[1] != expected {
+		t.Fatalf("Expected %q, got %q", expected, lines[1])
+	}
+
+	if err := d.WriteInventory(&buf, "xml"); err == nil {
+		t.Fatal("Expected an error for an unknown format")
+	}
+
+	infos, err := d.LayerInfos()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := graphdriver.LayerInfo{ID: "1", Size: -1, Children: 2}
+	if len(infos) != 3 || infos[0] != expected {
+		t.Fatalf("Expected %+v first, got %+v", expected, infos)
+	}
+}
+
+func TestCheckRemove(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+	d.options.verifyRemove = true
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Create("2", "1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.CheckRemove("1"); err == nil {
+		t.Fatal("Expected layer 1 to be reported as in use")
+	} else if _, ok := err.(ErrLayerInUse); !ok {
+		t.Fatalf("Expected ErrLayerInUse, got %v", err)
+	}
+	if err := d.CheckRemove("2"); err != nil {
+		t.Fatal(err)
+	}
+	// Nothing was removed
+	if !d.Exists("1") || !d.Exists("2") {
+		t.Fatal("Expected CheckRemove to leave the layers in place")
+	}
+}
+
+func TestStatusDoesNotWaitForTheDriverLock(t *testing.T) {
+	d := newLocalDriver(t)
+	defer os.RemoveAll(tmp)
+
+	if err := d.Create("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.Get("1", ""); err != nil {
+		t.Fatal(err)
+	}
+	defer d.Put("1")
+
+	// As during a hung mount
+	d.Lock()
+	defer d.Unlock()
+	done := make(chan *StatusInfo)
+	go func() {
+		done <- d.statusInfo()
+	}()
+	select {
+	case info := <-done:
+		if info.ActiveLayers != 1 {
+			t.Fatalf("Expected 1 active layer, got %d", info.ActiveLayers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Status waited for the driver lock")
+	}
+}
@@ -0,0 +1,118 @@
+// +build linux
+
+package aufs
+
+import (
+	"os"
+	"sort"
+	"sync"
+)
+
+// removeWorkers bounds the number of directory trees deleted in parallel
+// by RemoveMany.
+const removeWorkers = 8
+
+// RemoveMany unmounts and removes the given layers, taking the driver
+// lock once for the whole batch and deleting the directory trees in
+// parallel afterwards. Layers are removed children first. It returns the
+// errors of the ids that could not be removed, or nil if all of them were.
+func (a *Driver) RemoveMany(ids []string) map[string]error {
+	errs := make(map[string]error)
+
+	// The dependents of each layer that are part of the batch: they don't
+	// hold the layer back, as long as they are removed first
+	inBatch := make(map[string][]string)
+	if a.options.verifyRemove {
+		batch := make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			batch[id] = struct{}{}
+		}
+		for _, id := range ids {
+			dependents, err := a.Dependents(id)
+			if err != nil {
+				errs[id] = err
+				continue
+			}
+			var outside []string
+			for _, d := range dependents {
+				if _, ok := batch[d]; ok {
+					inBatch[id] = append(inBatch[id], d)
+				} else {
+					outside = append(outside, d)
+				}
+			}
+			if len(outside) > 0 {
+				errs[id] = ErrLayerInUse{ID: id, Dependents: outside}
+			}
+		}
+	}
+
+	depths := make(map[string]int, len(ids))
+	for _, id := range ids {
+		parents, _ := getParentIds(a.rootPath(), id)
+		depths[id] = len(parents)
+	}
+	ordered := byDepth{ids: append([]string{}, ids...), depths: depths}
+	sort.Stable(ordered)
+
+	var tmpPaths []string
+	removed := make(map[string]bool, len(ids))
+	a.Lock()
+	for _, id := range ordered.ids {
+		if errs[id] != nil {
+			continue
+		}
+		// A dependent that could not be removed still needs the layer
+		var left []string
+		for _, d := range inBatch[id] {
+			if !removed[d] {
+				left = append(left, d)
+			}
+		}
+		if len(left) > 0 {
+			errs[id] = ErrLayerInUse{ID: id, Dependents: left}
+			continue
+		}
+		paths, err := a.removeLocked(id)
+		tmpPaths = append(tmpPaths, paths...)
+		if err != nil {
+			errs[id] = err
+			continue
+		}
+		removed[id] = true
+	}
+	a.Unlock()
+
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < removeWorkers && i < len(tmpPaths); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for p := range work {
+				os.RemoveAll(p)
+			}
+		}()
+	}
+	for _, p := range tmpPaths {
+		work <- p
+	}
+	close(work)
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// byDepth sorts layer ids from the longest chain to the shortest, so that
+// layers come before their parents.
+type byDepth struct {
+	ids    []string
+	depths map[string]int
+}
+
+func (s byDepth) Len() int           { return len(s.ids) }
+func (s byDepth) Less(i, j int) bool { return s.depths[s.ids[i]] > s.depths[s.ids[j]] }
+func (s byDepth) Swap(i, j int)      { s.ids[i], s.ids[j] = s.ids[j], s.ids[i] }
@@ -0,0 +1,153 @@
+// +build linux
+
+package aufs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/Sirupsen/logrus"
+	mountpk "github.com/docker/docker/pkg/mount"
+)
+
+// BindReadOnly bind-mounts the diff directory of the layer with the given
+// id read-only at target, so that scanners and backup agents can look at
+// the content of a single layer without going through a container. The
+// layer cannot be removed until the export is released with
+// UnbindReadOnly or by Cleanup. The target must not be a mountpoint.
+func (a *Driver) BindReadOnly(id, target string) error {
+	target = filepath.Clean(target)
+
+	a.Lock()
+	defer a.Unlock()
+
+	if !a.Exists(id) {
+		return fmt.Errorf("aufs: unknown layer %s", id)
+	}
+	if _, ok := a.bindExports[target]; ok {
+		return fmt.Errorf("aufs: %s is already in use by an export", target)
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	// Mounting over another mount would leave it hidden but in place
+	if mounted, err := mountpk.Mounted(target); err != nil {
+		return err
+	} else if mounted {
+		return fmt.Errorf("aufs: %s is already a mountpoint", target)
+	}
+	if err := mountpk.Mount(a.layerPath("diff", id), target, "none", "bind,ro"); err != nil {
+		return fmt.Errorf("aufs: exporting %s at %s: %v", id, target, err)
+	}
+	a.bindExports[target] = id
+	a.updateUsage()
+	if err := a.saveExports(); err != nil {
+		mountpk.Unmount(target)
+		delete(a.bindExports, target)
+		a.updateUsage()
+		return err
+	}
+	return nil
+}
+
+// The exports are recorded in the exports file, so that the ones a crash
+// leaves mounted still protect their layer after a restart. Cleanup
+// releases them all.
+
+func (a *Driver) exportsPath() string {
+	return path.Join(a.rootPath(), "exports")
+}
+
+// UnbindReadOnly releases an export made with BindReadOnly.
+func (a *Driver) UnbindReadOnly(target string) error {
+	target = filepath.Clean(target)
+
+	a.Lock()
+	defer a.Unlock()
+
+	if _, ok := a.bindExports[target]; !ok {
+		return fmt.Errorf("aufs: nothing exported at %s", target)
+	}
+	if err := mountpk.Unmount(target); err != nil {
+		return err
+	}
+	delete(a.bindExports, target)
+	a.updateUsage()
+	return a.saveExports()
+}
+
+// unbindAll releases every export, for Cleanup. Must be called with the
+// driver lock held.
+func (a *Driver) unbindAll() error {
+	for target, id := range a.bindExports {
+		if err := mountpk.Unmount(target); err != nil {
+			logrus.Errorf("aufs: releasing the export of %s at %s: %v", id, target, err)
+			continue
+		}
+		delete(a.bindExports, target)
+	}
+	a.updateUsage()
+	return a.saveExports()
+}
+
+// loadExports reads the exports file, keeping the exports that are still
+// mounted.
+func (a *Driver) loadExports() error {
+	b, err := ioutil.ReadFile(a.exportsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var exports map[string]string
+	if err := json.Unmarshal(b, &exports); err != nil {
+		return fmt.Errorf("aufs: corrupted exports file %s: %v", a.exportsPath(), err)
+	}
+	a.Lock()
+	defer a.Unlock()
+	for target, id := range exports {
+		if mounted, err := mountpk.Mounted(target); err != nil || !mounted {
+			continue
+		}
+		a.bindExports[target] = id
+	}
+	a.updateUsage()
+	return a.saveExports()
+}
+
+// saveExports writes the exports file. Must be called with the driver
+// lock held.
+func (a *Driver) saveExports() error {
+	if len(a.bindExports) == 0 {
+		if err := os.Remove(a.exportsPath()); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	b, err := json.Marshal(a.bindExports)
+	if err != nil {
+		return err
+	}
+	tmp := a.exportsPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, a.exportsPath())
+}
+
+// exportedAt returns the targets the layer with the given id is exported
+// at. Must be called with the driver lock held.
+func (a *Driver) exportedAt(id string) []string {
+	var targets []string
+	for target, exported := range a.bindExports {
+		if exported == id {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
@@ -0,0 +1,64 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// defaultMaxBranches matches the default CONFIG_AUFS_BRANCH_MAX_127 of
+// the aufs module.
+const defaultMaxBranches = 127
+
+// budgetWarnRatio is the share of a limit above which new mounts are
+// logged as getting close to it.
+const budgetWarnRatio = 0.9
+
+// ErrMountBudget is returned instead of mounting when the mount would go
+// past the configured branch or mount limits, rather than letting the
+// kernel fail with an obscure error.
+type ErrMountBudget struct {
+	ID    string
+	What  string
+	Used  int
+	Limit int
+}
+
+func (e ErrMountBudget) Error() string {
+	return fmt.Sprintf("aufs: cannot mount %s: %s limit reached (%d/%d)", stringid.TruncateID(e.ID), e.What, e.Used, e.Limit)
+}
+
+// checkMountBudget verifies that mounting id with the given number of
+// branches stays within the limits. Must be called with the driver lock
+// held.
+func (a *Driver) checkMountBudget(id string, branches int) error {
+	maxBranches := a.branchLimit()
+	if branches > maxBranches {
+		return ErrMountBudget{ID: id, What: "branch", Used: branches, Limit: maxBranches}
+	}
+	if float64(branches) > budgetWarnRatio*float64(maxBranches) {
+		logrus.Warnf("aufs: %s uses %d of at most %d branches", stringid.TruncateID(id), branches, maxBranches)
+	}
+
+	if maxMounts := a.options.maxMounts; maxMounts > 0 {
+		used := len(a.mountedBranches)
+		if used >= maxMounts {
+			return ErrMountBudget{ID: id, What: "mount", Used: used, Limit: maxMounts}
+		}
+		if float64(used+1) > budgetWarnRatio*float64(maxMounts) {
+			logrus.Warnf("aufs: %d of at most %d aufs mounts in use", used+1, maxMounts)
+		}
+	}
+	return nil
+}
+
+// branchLimit returns the maximum number of branches of a single mount.
+func (a *Driver) branchLimit() int {
+	if a.options.maxBranches == 0 {
+		return defaultMaxBranches
+	}
+	return a.options.maxBranches
+}
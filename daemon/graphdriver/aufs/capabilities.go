@@ -0,0 +1,83 @@
+// +build linux
+
+package aufs
+
+// driverAPIVersion is the version of the operations this driver offers on
+// top of the graphdriver interface. It is bumped when one of them changes
+// in a way callers can notice; adding operations does not bump it.
+const driverAPIVersion = 1
+
+// metadataVersion is the version of the format of the layers files:
+// 1 is a bare list of parent ids, 2 adds the digest and size of each
+// layer and a checksum.
+const metadataVersion = 2
+
+// operations lists the operations of the driver beyond the graphdriver
+// interface, so tools can check for them rather than for a build. They
+// are methods of the driver for the daemon and tools linking it in, not
+// endpoints of the remote API. Every exported method must be listed,
+// TestOperations checks it.
+var operations = []string{
+	"access-times",
+	"adopt",
+	"annotations",
+	"approximate-changes",
+	"bind-readonly",
+	"chain-size",
+	"check-remove",
+	"dependents",
+	"dump",
+	"export-diff",
+	"import-staged",
+	"inventory",
+	"journal",
+	"layout-migration",
+	"list-layers",
+	"logical-size",
+	"mount-stats",
+	"oci-layout",
+	"pin",
+	"placement",
+	"provenance",
+	"rebuild-metadata",
+	"refresh",
+	"remove-dry-run",
+	"remove-many",
+	"replace-parent",
+	"retire",
+	"scavenge",
+	"search",
+	"selftest",
+	"validate-chain",
+	"verify-layers",
+}
+
+// Capabilities describes what the driver supports.
+type Capabilities struct {
+	APIVersion      int      `json:"apiVersion"`
+	MetadataVersion int      `json:"metadataVersion"`
+	Backend         string   `json:"backend"`
+	Operations      []string `json:"operations"`
+	Limits          Limits   `json:"limits"`
+}
+
+// Limits are the bounds the driver enforces. Zero means no limit.
+type Limits struct {
+	MaxBranches int `json:"maxBranches"`
+	MaxMounts   int `json:"maxMounts"`
+}
+
+// Capabilities returns what the driver supports.
+func (a *Driver) Capabilities() Capabilities {
+	return Capabilities{
+		APIVersion:      driverAPIVersion,
+		MetadataVersion: metadataVersion,
+		// All layers are stored under the root of the driver
+		Backend:    "local",
+		Operations: append([]string{}, operations...),
+		Limits: Limits{
+			MaxBranches: a.branchLimit(),
+			MaxMounts:   a.options.maxMounts,
+		},
+	}
+}
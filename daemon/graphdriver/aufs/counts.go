@@ -0,0 +1,79 @@
+// +build linux
+
+package aufs
+
+import (
+	"path"
+	"time"
+)
+
+// The number of layers reported by Status is kept up to date by the
+// operations that add and remove layers, so that Status never has to list
+// the store, which is slow on large or slow filesystems. Refresh counts
+// them again from disk.
+
+// usage holds the sizes of the maps protected by the driver lock that
+// Status reports, copied so that Status never waits for the driver lock,
+// which a hung mount can hold for good.
+type usage struct {
+	active   int
+	exports  int
+	mounts   int
+	branches int
+}
+
+// updateUsage copies the sizes of the maps protected by the driver lock.
+// Must be called with the driver lock held, after changing them.
+func (a *Driver) updateUsage() {
+	u := usage{
+		active:  len(a.active),
+		exports: len(a.bindExports),
+		mounts:  len(a.mountedBranches),
+	}
+	for _, b := range a.mountedBranches {
+		u.branches += b
+	}
+	a.countLock.Lock()
+	a.usage = u
+	a.countLock.Unlock()
+}
+
+func (a *Driver) currentUsage() usage {
+	a.countLock.Lock()
+	defer a.countLock.Unlock()
+	return a.usage
+}
+
+// countLayers adjusts the number of layers by delta.
+func (a *Driver) countLayers(delta int) {
+	a.countLock.Lock()
+	a.layerCount += delta
+	a.countLock.Unlock()
+}
+
+// countedLayers returns the number of layers and when they were last counted
+// from disk.
+func (a *Driver) countedLayers() (int, time.Time) {
+	a.countLock.Lock()
+	defer a.countLock.Unlock()
+	return a.layerCount, a.countedAt
+}
+
+// Refresh counts the layers of the store from disk, for when the number
+// reported by Status is suspected to be off, e.g. after layers were added
+// or removed by hand. It returns the number of layers.
+func (a *Driver) Refresh() (int, error) {
+	var n int
+	err := walkIds(path.Join(a.rootPath(), "layers"), "", func(string) error {
+		n++
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	a.countLock.Lock()
+	a.layerCount = n
+	a.countedAt = time.Now()
+	a.countLock.Unlock()
+	return n, nil
+}
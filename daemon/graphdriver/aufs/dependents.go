@@ -0,0 +1,48 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ErrLayerInUse is returned by Remove, when removals are verified, for a
+// layer that other layers are still built on.
+type ErrLayerInUse struct {
+	ID         string
+	Dependents []string
+}
+
+func (e ErrLayerInUse) Error() string {
+	return fmt.Sprintf("aufs: layer %s is still used by %s", e.ID, strings.Join(e.Dependents, ", "))
+}
+
+// Dependents returns the sorted ids of the layers whose chain goes through
+// the layer with the given id.
+func (a *Driver) Dependents(id string) ([]string, error) {
+	var dependents []string
+	err := walkIds(path.Join(a.rootPath(), "layers"), "", func(other string) error {
+		if other == id {
+			return nil
+		}
+		parents, err := getParentIds(a.rootPath(), other)
+		if err != nil {
+			return err
+		}
+		for _, p := range parents {
+			if p == id {
+				dependents = append(dependents, other)
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(dependents)
+	return dependents, nil
+}
@@ -3,46 +3,350 @@
 package aufs
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
+// checksumPrefix starts the last line of a layers file, which holds the
+// crc32 of everything above it. Files written before the checksum was
+// introduced don't have it and are trusted as they are.
+const checksumPrefix = "crc32:"
+
+// selfPrefix starts the optional line of a layers file that records the
+// digest and size of the layer itself, optionally followed by the digest
+// and size of its content.
+const selfPrefix = "#layer"
+
+// readdirBatch is how many entries walkIds reads from a directory at a
+// time.
+const readdirBatch = 1024
+
+// errStopWalk can be returned by the function passed to walkIds to stop
+// the walk early without an error.
+var errStopWalk = errors.New("stop walk")
+
+// walkIds calls fn for every file in root and its shard directories whose
+// name starts with prefix, in directory order, reading the directories in
+// batches so that they never have to be held in memory as a whole. Other
+// directories and the leftovers of an interrupted writeParentIds are
+// skipped.
+func walkIds(root, prefix string, fn func(id string) error) error {
+	err := walkDir(root, true, prefix, fn)
+	if err == errStopWalk {
+		return nil
+	}
+	return err
+}
+
+func walkDir(dir string, top bool, prefix string, fn func(id string) error) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for {
+		fis, err := f.Readdir(readdirBatch)
+		for _, fi := range fis {
+			name := fi.Name()
+			if fi.IsDir() {
+				if top && isShardDir(name) && (strings.HasPrefix(name, prefix) || strings.HasPrefix(prefix, name)) {
+					if err := walkDir(path.Join(dir, name), false, prefix, fn); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if !strings.HasPrefix(name, prefix) || strings.HasSuffix(name, ".tmp") {
+				continue
+			}
+			if err := fn(name); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
 // Return all the directories
 func loadIds(root string) ([]string, error) {
-	dirs, err := ioutil.ReadDir(root)
+	out := []string{}
+	err := walkIds(root, "", func(id string) error {
+		out = append(out, id)
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	out := []string{}
-	for _, d := range dirs {
-		if !d.IsDir() {
-			out = append(out, d.Name())
+	return out, nil
+}
+
+// ListLayers returns, in lexical order, at most limit ids of layers that
+// start with prefix and sort after after, so that callers can page
+// through the layers of a large store. A limit of 0 means no limit. Only
+// one page of ids is held in memory at a time.
+func (a *Driver) ListLayers(prefix, after string, limit int) ([]string, error) {
+	var page []string
+	err := walkIds(path.Join(a.rootPath(), "layers"), prefix, func(id string) error {
+		if id <= after {
+			return nil
 		}
+		page = append(page, id)
+		if limit > 0 && len(page) >= 2*limit {
+			sort.Strings(page)
+			page = page[:limit]
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return out, nil
+	sort.Strings(page)
+	if limit > 0 && len(page) > limit {
+		page = page[:limit]
+	}
+	return page, nil
+}
+
+// chainEntry is a layer of a chain as recorded in a layers file. Next to
+// the id, the digest of the diff the layer was applied from and the size
+// of the layer are recorded when they are known. An unknown size is -1.
+// The layer itself may also record the digest and size of the archive
+// Diff makes of it, which VerifyLayers checks its content against.
+type chainEntry struct {
+	id            string
+	digest        string
+	size          int64
+	contentDigest string
+	contentSize   int64
+}
+
+func idsToEntries(ids []string) []chainEntry {
+	entries := make([]chainEntry, len(ids))
+	for i, id := range ids {
+		entries[i] = chainEntry{id: id, size: -1}
+	}
+	return entries
+}
+
+// chainKey identifies a version of a layers file. writeChain replaces
+// the file, so a rewrite shows up as a new inode even within the mtime
+// granularity of the filesystem.
+type chainKey struct {
+	ino   uint64
+	size  int64
+	mtime time.Time
+}
+
+type cachedChain struct {
+	key     chainKey
+	self    chainEntry
+	parents []chainEntry
 }
 
+// chainCache memoizes the parsed layers files by path, so that starting
+// containers from the same images over and over does not parse the same
+// chains every time.
+var chainCache = struct {
+	sync.Mutex
+	m map[string]cachedChain
+}{m: make(map[string]cachedChain)}
+
 // Read the layers file for the current id and return all the
 // layers represented by new lines in the file
 //
 // If there are no lines in the file then the id has no parent
 // and an empty slice is returned.
 func getParentIds(root, id string) ([]string, error) {
-	f, err := os.Open(path.Join(root, "layers", id))
+	_, parents, err := readChain(root, id)
 	if err != nil {
 		return nil, err
 	}
+	out := make([]string, len(parents))
+	for i, p := range parents {
+		out[i] = p.id
+	}
+	return out, nil
+}
+
+// readChain returns what the layers file of id records about the layer
+// itself and about its parents, closest first.
+func readChain(root, id string) (chainEntry, []chainEntry, error) {
+	// Cached by the flat path, whatever the layout the file is in
+	p := path.Join(root, "layers", id)
+	f, err := os.Open(layerPath(root, "layers", id))
+	if err != nil {
+		return chainEntry{}, nil, err
+	}
 	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return chainEntry{}, nil, err
+	}
+	key := chainKey{size: fi.Size(), mtime: fi.ModTime()}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		key.ino = st.Ino
+	}
 
-	out := []string{}
-	s := bufio.NewScanner(f)
+	chainCache.Lock()
+	cached, ok := chainCache.m[p]
+	chainCache.Unlock()
+	if ok && cached.key == key {
+		return cached.self, append([]chainEntry{}, cached.parents...), nil
+	}
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return chainEntry{}, nil, err
+	}
+	self, parents, err := parseChain(id, content)
+	if err != nil {
+		return chainEntry{}, nil, err
+	}
+
+	chainCache.Lock()
+	chainCache.m[p] = cachedChain{key: key, self: self, parents: parents}
+	chainCache.Unlock()
+	return self, append([]chainEntry{}, parents...), nil
+}
 
-	for s.Scan() {
-		if t := s.Text(); t != "" {
-			out = append(out, s.Text())
+// forgetParentIds drops the memoized chain of a removed layer.
+func forgetParentIds(root, id string) {
+	chainCache.Lock()
+	delete(chainCache.m, path.Join(root, "layers", id))
+	chainCache.Unlock()
+}
+
+// parseChain parses the content of the layers file of id. Each parent is
+// on a line of its own, either as a bare id or followed by its digest
+// and size. A "#layer <digest> <size>" line describes the layer itself,
+// optionally followed by the digest and size of its content, and the
+// file ends with the checksum of what precedes it. Readers that predate
+// the content digest ignore the longer line, so the version is unchanged.
+func parseChain(id string, content []byte) (chainEntry, []chainEntry, error) {
+	self := chainEntry{id: id, size: -1}
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if last := lines[len(lines)-1]; strings.HasPrefix(last, checksumPrefix) {
+		body := content[:bytes.LastIndex(content, []byte(last))]
+		if strings.TrimPrefix(last, checksumPrefix) != fmt.Sprintf("%08x", crc32.ChecksumIEEE(body)) {
+			return self, nil, fmt.Errorf("aufs: layers file of %s is corrupted: checksum mismatch", id)
+		}
+		lines = lines[:len(lines)-1]
+	}
+
+	parents := []chainEntry{}
+	for _, t := range lines {
+		fields := strings.Fields(t)
+		switch {
+		case len(fields) == 0:
+			continue
+		case fields[0] == selfPrefix:
+			if len(fields) >= 3 {
+				self.digest, self.size = fields[1], parseSize(fields[2])
+			}
+			if len(fields) == 5 {
+				self.contentDigest, self.contentSize = fields[3], parseSize(fields[4])
+			}
+		case strings.HasPrefix(fields[0], "#"):
+			// Reserved for future use
+		case len(fields) == 3:
+			parents = append(parents, chainEntry{id: fields[0], digest: fields[1], size: parseSize(fields[2])})
+		default:
+			parents = append(parents, chainEntry{id: fields[0], size: -1})
+		}
+	}
+	return self, parents, nil
+}
+
+func parseSize(s string) int64 {
+	size, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// writeParentIds atomically replaces the layers file of id with the given
+// chain of bare ids.
+func (a *Driver) writeParentIds(id string, parents []string) error {
+	return a.writeChain(chainEntry{id: id, size: -1}, idsToEntries(parents))
+}
+
+// writeChain atomically replaces the layers file of self with the given
+// chain, followed by its checksum.
+func (a *Driver) writeChain(self chainEntry, parents []chainEntry) error {
+	var buf bytes.Buffer
+	if self.digest != "" && self.contentDigest != "" {
+		fmt.Fprintf(&buf, "%s %s %d %s %d\n", selfPrefix, self.digest, self.size, self.contentDigest, self.contentSize)
+	} else if self.digest != "" {
+		fmt.Fprintf(&buf, "%s %s %d\n", selfPrefix, self.digest, self.size)
+	}
+	for _, p := range parents {
+		if p.digest != "" {
+			fmt.Fprintf(&buf, "%s %s %d\n", p.id, p.digest, p.size)
+		} else {
+			fmt.Fprintln(&buf, p.id)
+		}
+	}
+	fmt.Fprintf(&buf, "%s%08x\n", checksumPrefix, crc32.ChecksumIEEE(buf.Bytes()))
+
+	p, err := a.createLayerPath("layers", self.id)
+	if err != nil {
+		return err
+	}
+	tmp := p + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, p)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// ChainSize returns the size of the layer with the given id and all its
+// parents as recorded when they were applied, without walking their
+// directories. It fails if the size of one of them is not recorded.
+func (a *Driver) ChainSize(id string) (int64, error) {
+	self, parents, err := readChain(a.rootPath(), id)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, e := range append([]chainEntry{self}, parents...) {
+		if e.size < 0 {
+			return 0, fmt.Errorf("aufs: size of layer %s is not recorded", e.id)
 		}
+		total += e.size
 	}
-	return out, s.Err()
+	return total, nil
 }
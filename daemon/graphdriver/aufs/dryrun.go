@@ -0,0 +1,91 @@
+// +build linux
+
+package aufs
+
+import (
+	"os"
+	"sort"
+)
+
+// RemoveReport describes what removing a layer would touch on disk.
+type RemoveReport struct {
+	ID     string `json:"id"`
+	Active int    `json:"active"`
+	Pinned bool   `json:"pinned,omitempty"`
+	// Exports are the read-only exports of the layer, and Dependents the
+	// layers built on it when aufs.verifyremove is set. Either refuses
+	// the removal.
+	Exports     []string `json:"exports,omitempty"`
+	Dependents  []string `json:"dependents,omitempty"`
+	Unmount     []string `json:"unmount,omitempty"`
+	Directories []string `json:"directories,omitempty"`
+	Metadata    []string `json:"metadata,omitempty"`
+}
+
+// RemoveDryRun reports which mounts, directories and metadata entries
+// Remove would touch for the given id, and what would refuse the removal,
+// without changing anything. The report marshals to JSON for operators to
+// vet cleanups.
+func (a *Driver) RemoveDryRun(id string) (*RemoveReport, error) {
+	// Like Remove, look for dependents before taking the lock
+	var dependents []string
+	if a.options.verifyRemove {
+		var err error
+		if dependents, err = a.Dependents(id); err != nil {
+			return nil, err
+		}
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	r, err := a.removeReport(id)
+	if err != nil {
+		return nil, err
+	}
+	r.Dependents = dependents
+	return r, nil
+}
+
+// removeReport builds the removal plan for id. Must be called with the
+// driver lock held.
+func (a *Driver) removeReport(id string) (*RemoveReport, error) {
+	r := &RemoveReport{
+		ID:     id,
+		Active: a.active[id],
+	}
+
+	pinned, err := a.isPinned(id)
+	if err != nil {
+		return nil, err
+	}
+	r.Pinned = pinned
+	r.Exports = a.exportedAt(id)
+	sort.Strings(r.Exports)
+
+	mounted, err := a.mounted(id)
+	if err != nil {
+		return nil, err
+	}
+	if mounted {
+		r.Unmount = append(r.Unmount, a.layerPath("mnt", id))
+	}
+
+	for _, p := range []string{"mnt", "diff"} {
+		dir := a.layerPath(p, id)
+		if _, err := os.Lstat(dir); err == nil {
+			r.Directories = append(r.Directories, dir)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	for _, p := range []string{a.layerPath("layers", id), a.annotationsPath(id)} {
+		if _, err := os.Lstat(p); err == nil {
+			r.Metadata = append(r.Metadata, p)
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	return r, nil
+}
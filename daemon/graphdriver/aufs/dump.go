@@ -0,0 +1,108 @@
+// +build linux
+
+package aufs
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	mountpk "github.com/docker/docker/pkg/mount"
+)
+
+// Dump writes a tar archive describing the state of the driver to w, for
+// attaching to bug reports: the Status output, the active reference
+// counts, the mounts under the driver root and all the metadata files. No
+// layer content is included.
+func (a *Driver) Dump(w io.Writer) error {
+	tw := tar.NewWriter(w)
+	now := time.Now()
+
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: now,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	var status bytes.Buffer
+	for _, s := range a.Status() {
+		fmt.Fprintf(&status, "%s: %s\n", s[0], s[1])
+	}
+	if err := add("status", status.Bytes()); err != nil {
+		return err
+	}
+
+	a.Lock()
+	active, err := json.MarshalIndent(a.active, "", "  ")
+	a.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := add("active.json", active); err != nil {
+		return err
+	}
+
+	mounts, err := mountpk.GetMounts()
+	if err != nil {
+		return err
+	}
+	var table bytes.Buffer
+	for _, m := range mounts {
+		if strings.HasPrefix(m.Mountpoint, a.rootPath()) {
+			fmt.Fprintf(&table, "%s %s %s %s %s\n", m.Source, m.Mountpoint, m.Fstype, m.Opts, m.VfsOpts)
+		}
+	}
+	if err := add("mounts", table.Bytes()); err != nil {
+		return err
+	}
+
+	if err := dumpFile(add, a.journalPath(), "journal"); err != nil {
+		return err
+	}
+	for _, dir := range []string{"layers", "annotations"} {
+		ids, err := loadIds(path.Join(a.rootPath(), dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, id := range ids {
+			p := path.Join(a.rootPath(), dir, id)
+			if dir == "layers" {
+				p = a.layerPath(dir, id)
+			}
+			if err := dumpFile(add, p, path.Join(dir, id)); err != nil {
+				return err
+			}
+		}
+	}
+	return tw.Close()
+}
+
+// dumpFile adds the file at p under name, skipping it if it went away.
+func dumpFile(add func(string, []byte) error, p, name string) error {
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return add(name, data)
+}
@@ -0,0 +1,108 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// durability tells how hard the driver tries to get a layer onto stable
+// storage before reporting it as complete.
+type durability int
+
+const (
+	// durabilityNone leaves writeback to the kernel.
+	durabilityNone durability = iota
+	// durabilityMetadata syncs the layers file and the directories
+	// holding the layer, so a layer never shows up with a torn chain.
+	durabilityMetadata
+	// durabilityFull additionally syncs every file of the layer content.
+	durabilityFull
+)
+
+func parseDurability(val string) (durability, error) {
+	switch val {
+	case "none":
+		return durabilityNone, nil
+	case "metadata":
+		return durabilityMetadata, nil
+	case "full":
+		return durabilityFull, nil
+	}
+	return durabilityNone, fmt.Errorf("Invalid durability %q, expected one of none, metadata or full", val)
+}
+
+func (d durability) String() string {
+	switch d {
+	case durabilityMetadata:
+		return "metadata"
+	case durabilityFull:
+		return "full"
+	}
+	return "none"
+}
+
+// syncLayer flushes the layer with the given id according to the
+// configured durability policy.
+func (a *Driver) syncLayer(id string) error {
+	if a.options.durability == durabilityNone {
+		return nil
+	}
+	diff := a.layerPath("diff", id)
+	if a.options.durability == durabilityFull {
+		if err := syncTree(diff); err != nil {
+			return err
+		}
+	} else if err := syncPath(diff); err != nil {
+		return err
+	}
+	for _, p := range []string{
+		a.layerPath("layers", id),
+		path.Dir(a.layerPath("layers", id)),
+		path.Dir(diff),
+	} {
+		if err := syncPath(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// syncTree fsyncs every regular file and directory under root, children
+// before their parents.
+func syncTree(root string) error {
+	var dirs []string
+	err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case fi.IsDir():
+			dirs = append(dirs, p)
+		case fi.Mode().IsRegular():
+			return syncPath(p)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := syncPath(dirs[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func syncPath(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
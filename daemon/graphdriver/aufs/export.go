@@ -0,0 +1,39 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/pkg/archive"
+)
+
+// ExportManifest references the layers a container's rw diff sits on, so
+// the receiving side can rebuild the chain from layers it already has
+// instead of shipping them again.
+type ExportManifest struct {
+	ID string `json:"id"`
+	// Parents lists the layers below ID, closest first, as in the
+	// layers file.
+	Parents []string `json:"parents"`
+}
+
+// ExportDiff returns an archive of the rw diff of the given container
+// layer together with a manifest of the layers below it. Unlike a full
+// rootfs export, none of the underlying image layers are included.
+func (a *Driver) ExportDiff(id string) (archive.Archive, *ExportManifest, error) {
+	parents, err := getParentIds(a.rootPath(), id)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range parents {
+		if !a.Exists(p) {
+			return nil, nil, fmt.Errorf("aufs: cannot export %s: parent layer %s is missing", id, p)
+		}
+	}
+	arch, err := a.Diff(id, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return arch, &ExportManifest{ID: id, Parents: parents}, nil
+}
@@ -0,0 +1,143 @@
+// +build linux
+
+package aufs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/daemon/graphdriver"
+)
+
+// InventoryEntry describes a layer of the store for asset inventories.
+type InventoryEntry struct {
+	ID     string `json:"id"`
+	Digest string `json:"digest,omitempty"`
+	// Size is the size of the diff the layer was applied from, -1 if
+	// unknown.
+	Size     int64  `json:"size"`
+	Location string `json:"location"`
+	// Parents are the layers the layer is built on, closest first.
+	Parents []string `json:"parents"`
+	// Children are the layers directly built on the layer, which includes
+	// the containers created from an image.
+	Children []string   `json:"children"`
+	Active   int        `json:"active"`
+	LastUsed *time.Time `json:"lastUsed,omitempty"`
+}
+
+// Inventory returns a description of every layer of the store, sorted by
+// id.
+func (a *Driver) Inventory() ([]InventoryEntry, error) {
+	var entries []InventoryEntry
+	children := make(map[string][]string)
+	err := walkIds(path.Join(a.rootPath(), "layers"), "", func(id string) error {
+		self, parents, err := readChain(a.rootPath(), id)
+		if err != nil {
+			return err
+		}
+		e := InventoryEntry{
+			ID:       id,
+			Digest:   self.digest,
+			Size:     self.size,
+			Location: a.layerPath("diff", id),
+			Parents:  make([]string, len(parents)),
+		}
+		for i, p := range parents {
+			e.Parents[i] = p.id
+		}
+		if len(parents) > 0 {
+			children[parents[0].id] = append(children[parents[0].id], id)
+		}
+		entries = append(entries, e)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Sort(inventoryById(entries))
+
+	a.Lock()
+	defer a.Unlock()
+	for i := range entries {
+		e := &entries[i]
+		e.Children = children[e.ID]
+		sort.Strings(e.Children)
+		e.Active = a.active[e.ID]
+		if t, ok := a.accessTimes[e.ID]; ok {
+			e.LastUsed = &t
+		}
+	}
+	return entries, nil
+}
+
+// LayerInfos returns the Inventory in the form the daemon serves it in.
+func (a *Driver) LayerInfos() ([]graphdriver.LayerInfo, error) {
+	entries, err := a.Inventory()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]graphdriver.LayerInfo, len(entries))
+	for i, e := range entries {
+		infos[i] = graphdriver.LayerInfo{
+			ID:       e.ID,
+			Size:     e.Size,
+			Parents:  len(e.Parents),
+			Children: len(e.Children),
+			Active:   e.Active,
+		}
+	}
+	return infos, nil
+}
+
+type inventoryById []InventoryEntry
+
+func (s inventoryById) Len() int           { return len(s) }
+func (s inventoryById) Less(i, j int) bool { return s[i].ID < s[j].ID }
+func (s inventoryById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+
+// WriteInventory writes the Inventory to w as "json" or "csv". In CSV,
+// lists are space-separated and times are in RFC 3339.
+func (a *Driver) WriteInventory(w io.Writer, format string) error {
+	entries, err := a.Inventory()
+	if err != nil {
+		return err
+	}
+	switch format {
+	case "json":
+		if entries == nil {
+			entries = []InventoryEntry{}
+		}
+		return json.NewEncoder(w).Encode(entries)
+	case "csv":
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"id", "digest", "size", "location", "parents", "children", "active", "last_used"})
+		for _, e := range entries {
+			var lastUsed string
+			if e.LastUsed != nil {
+				lastUsed = e.LastUsed.UTC().Format(time.RFC3339)
+			}
+			cw.Write([]string{
+				e.ID,
+				e.Digest,
+				strconv.FormatInt(e.Size, 10),
+				e.Location,
+				strings.Join(e.Parents, " "),
+				strings.Join(e.Children, " "),
+				strconv.Itoa(e.Active),
+				lastUsed,
+			})
+		}
+		cw.Flush()
+		return cw.Error()
+	default:
+		return fmt.Errorf("aufs: unknown inventory format %q", format)
+	}
+}
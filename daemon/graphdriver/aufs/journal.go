@@ -0,0 +1,118 @@
+// +build linux
+
+package aufs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// The journal is an append-only log of the layers added to and removed
+// from the store, one "<time> <event> <id>" line each, used to answer
+// what the store contained at a given point in time.
+
+const (
+	journalAdd    = "add"
+	journalRemove = "remove"
+)
+
+func (a *Driver) journalPath() string {
+	return path.Join(a.rootPath(), "journal")
+}
+
+// seedJournal starts the journal of a store that predates it with the
+// layers already present, dated by the time their metadata was written.
+func (a *Driver) seedJournal() error {
+	if _, err := os.Lstat(a.journalPath()); !os.IsNotExist(err) {
+		return err
+	}
+	ids, err := loadIds(path.Join(a.rootPath(), "layers"))
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.journalPath(), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(f)
+	for _, id := range ids {
+		fi, err := os.Lstat(a.layerPath("layers", id))
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(w, "%s %s %s\n", fi.ModTime().UTC().Format(time.RFC3339Nano), journalAdd, id)
+	}
+	return w.Flush()
+}
+
+// record appends an event to the journal. The journal is best effort:
+// failing to write it never fails the storage operation itself.
+func (a *Driver) record(event, id string) {
+	a.journalLock.Lock()
+	defer a.journalLock.Unlock()
+
+	f, err := os.OpenFile(a.journalPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		logrus.Errorf("aufs: recording %s of %s: %v", event, id, err)
+		return
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintf(f, "%s %s %s\n", time.Now().UTC().Format(time.RFC3339Nano), event, id); err != nil {
+		logrus.Errorf("aufs: recording %s of %s: %v", event, id, err)
+	}
+}
+
+// LayersAt returns the sorted ids of the layers the store contained at
+// the given time, according to the journal.
+func (a *Driver) LayersAt(t time.Time) ([]string, error) {
+	f, err := os.Open(a.journalPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	present := make(map[string]struct{})
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		when, err := time.Parse(time.RFC3339Nano, fields[0])
+		if err != nil {
+			continue
+		}
+		if when.After(t) {
+			// Seeded entries are not in order, keep reading
+			continue
+		}
+		switch fields[1] {
+		case journalAdd:
+			present[fields[2]] = struct{}{}
+		case journalRemove:
+			delete(present, fields[2])
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(present))
+	for id := range present {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
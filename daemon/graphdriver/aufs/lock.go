@@ -0,0 +1,42 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+)
+
+// lockRoot takes an exclusive lock on the driver root so that two daemons
+// pointed at the same directory don't corrupt each other's metadata. The
+// lock is held for as long as the returned file is open, and released by
+// the kernel if the daemon dies. The pid of the owner is written into the
+// lock file for diagnosis.
+func lockRoot(root string) (*os.File, error) {
+	p := path.Join(root, ".lock")
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			owner, _ := ioutil.ReadFile(p)
+			return nil, fmt.Errorf("aufs: %s is in use by another docker daemon (pid %s)", root, strings.TrimSpace(string(owner)))
+		}
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := fmt.Fprintf(f, "%d\n", os.Getpid()); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
@@ -0,0 +1,110 @@
+// +build linux
+
+package aufs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+const defaultMetricsInterval = time.Minute
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// startMetrics writes a snapshot of the driver status to the metrics file
+// at every interval until Cleanup is called. The file uses the text format
+// of Prometheus, so the textfile collector of node_exporter can pick it up.
+func (a *Driver) startMetrics() {
+	a.stopMetrics = make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(a.options.metricsInterval)
+		defer ticker.Stop()
+		for {
+			if err := a.writeMetricsFile(time.Now()); err != nil {
+				logrus.Errorf("aufs: writing metrics to %s: %v", a.options.metricsFile, err)
+			}
+			select {
+			case <-ticker.C:
+			case <-a.stopMetrics:
+				return
+			}
+		}
+	}()
+}
+
+// writeMetricsFile replaces the metrics file at once, the collector must
+// never read a partial snapshot.
+func (a *Driver) writeMetricsFile(now time.Time) error {
+	mounts, err := a.allMountStats()
+	if err != nil {
+		// Still write the rest
+		logrus.Warnf("aufs: reading the kernel stats of the mounts: %v", err)
+	}
+	var buf bytes.Buffer
+	writeMetrics(&buf, a.statusInfo(), mounts, now)
+
+	dir, base := filepath.Split(a.options.metricsFile)
+	// The collector only reads *.prom files, hide the temporary one
+	tmp, err := ioutil.TempFile(dir, "."+base+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), a.options.metricsFile)
+}
+
+func writeMetrics(w io.Writer, info *StatusInfo, mounts map[string]*SysfsStats, now time.Time) {
+	gauge := func(name, help string, value interface{}) {
+		fmt.Fprintf(w, "# HELP docker_aufs_%s %s\n# TYPE docker_aufs_%s gauge\ndocker_aufs_%s %v\n", name, help, name, name, value)
+	}
+
+	fmt.Fprintf(w, "# HELP docker_aufs_info Configuration of the aufs storage driver.\n# TYPE docker_aufs_info gauge\n")
+	fmt.Fprintf(w, "docker_aufs_info{root=\"%s\",backing_filesystem=\"%s\",durability=\"%s\",layout=\"%s\",profile=\"%s\"} 1\n",
+		labelEscaper.Replace(info.RootDir), info.BackingFilesystem, info.Durability, info.Layout, info.Profile)
+	gauge("layers", "Number of layers.", info.Dirs)
+	gauge("mounts", "Number of mounted layers.", info.Mounts)
+	gauge("mounted_branches", "Number of branches of the mounted layers.", info.MountedBranches)
+	gauge("active_layers", "Number of layers with a reference.", info.ActiveLayers)
+	gauge("readonly_exports", "Number of read-only exports of layers.", info.ReadOnlyExports)
+	if p := info.DirPool; p != nil {
+		gauge("dirpool_size", "Size of the pool of pre-created layer directories.", p.Size)
+		gauge("dirpool_free", "Free entries of the pool of pre-created layer directories.", p.Free)
+	}
+	if len(mounts) > 0 {
+		ids := make([]string, 0, len(mounts))
+		for id := range mounts {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Fprintf(w, "# HELP docker_aufs_mount_branches Number of branches of a mount, as seen by the kernel.\n# TYPE docker_aufs_mount_branches gauge\n")
+		for _, id := range ids {
+			fmt.Fprintf(w, "docker_aufs_mount_branches{id=\"%s\",si=\"%s\"} %d\n", id, mounts[id].SI, len(mounts[id].Branches))
+		}
+		fmt.Fprintf(w, "# HELP docker_aufs_mount_plinks Number of pseudo-links of a mount.\n# TYPE docker_aufs_mount_plinks gauge\n")
+		for _, id := range ids {
+			if mounts[id].Plinks >= 0 {
+				fmt.Fprintf(w, "docker_aufs_mount_plinks{id=\"%s\",si=\"%s\"} %d\n", id, mounts[id].SI, mounts[id].Plinks)
+			}
+		}
+	}
+	gauge("metrics_timestamp_seconds", "Time the metrics were written at.", now.Unix())
+}
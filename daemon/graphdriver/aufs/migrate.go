@@ -67,7 +67,7 @@ func (a *Driver) migrateContainers(pth string, setupInit func(p string) error) e
 
 	for _, fi := range fis {
 		if id := fi.Name(); fi.IsDir() && pathExists(path.Join(pth, id, "rw")) {
-			if err := tryRelocate(path.Join(pth, id, "rw"), path.Join(a.rootPath(), "diff", id)); err != nil {
+			if err := tryRelocate(path.Join(pth, id, "rw"), a.layerPath("diff", id)); err != nil {
 				return err
 			}
 
@@ -142,7 +142,7 @@ func (a *Driver) migrateImage(m *metadata, pth string, migrated map[string]bool)
 		if m.parent != nil {
 			a.migrateImage(m.parent, pth, migrated)
 		}
-		if err := tryRelocate(path.Join(pth, m.ID, "layer"), path.Join(a.rootPath(), "diff", m.ID)); err != nil {
+		if err := tryRelocate(path.Join(pth, m.ID, "layer"), a.layerPath("diff", m.ID)); err != nil {
 			return err
 		}
 		if !a.Exists(m.ID) {
@@ -0,0 +1,268 @@
+// +build linux
+
+package aufs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// Media types of the OCI image layout written and read by this driver.
+const (
+	ociLayoutVersion     = "1.0.0"
+	ociMediaTypeManifest = "application/vnd.oci.image.manifest.v1+json"
+	ociMediaTypeConfig   = "application/vnd.oci.image.config.v1+json"
+	ociMediaTypeLayer    = "application/vnd.oci.image.layer.v1.tar"
+	ociMediaTypeLayerGz  = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+type ociDescriptor struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+type ociIndex struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Manifests     []ociDescriptor `json:"manifests"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+type ociConfig struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	RootFS       struct {
+		Type    string   `json:"type"`
+		DiffIDs []string `json:"diff_ids"`
+	} `json:"rootfs"`
+}
+
+// ExportOCILayout writes the layer chain ending at id into dir as an OCI
+// image layout (oci-layout, index.json and blobs/sha256), so it can be
+// read by tools that don't speak to docker. The blobs are produced
+// straight from the diff directories of the chain.
+func (a *Driver) ExportOCILayout(id, dir string) error {
+	parents, err := getParentIds(a.rootPath(), id)
+	if err != nil {
+		return err
+	}
+	// The layers file lists the parents closest first, OCI wants the base first
+	chain := make([]string, 0, len(parents)+1)
+	for i := len(parents) - 1; i >= 0; i-- {
+		chain = append(chain, parents[i])
+	}
+	chain = append(chain, id)
+
+	if err := os.MkdirAll(filepath.Join(dir, "blobs", "sha256"), 0755); err != nil {
+		return err
+	}
+
+	var (
+		manifest = ociManifest{SchemaVersion: 2}
+		config   = ociConfig{Architecture: runtime.GOARCH, OS: runtime.GOOS}
+	)
+	config.RootFS.Type = "layers"
+	for _, layer := range chain {
+		diff, err := a.diff(layer, archive.OCIWhiteoutFormat)
+		if err != nil {
+			return err
+		}
+		desc, err := writeOCIBlob(dir, ociMediaTypeLayer, diff)
+		diff.Close()
+		if err != nil {
+			return fmt.Errorf("aufs: exporting layer %s: %v", stringid.TruncateID(layer), err)
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+		// Layers are uncompressed, so the diff id is the blob digest
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, desc.Digest)
+	}
+
+	if manifest.Config, err = writeOCIJSON(dir, ociMediaTypeConfig, config); err != nil {
+		return err
+	}
+	desc, err := writeOCIJSON(dir, ociMediaTypeManifest, manifest)
+	if err != nil {
+		return err
+	}
+	index, err := json.Marshal(ociIndex{SchemaVersion: 2, Manifests: []ociDescriptor{desc}})
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "index.json"), index, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "oci-layout"), []byte(fmt.Sprintf(`{"imageLayoutVersion":%q}`, ociLayoutVersion)), 0644)
+}
+
+// ImportOCILayout creates one layer per entry of the first manifest of the
+// OCI image layout in dir and returns their ids, base first. Blob digests
+// are verified while the layers are applied. If any layer fails, the
+// layers created so far are removed again.
+func (a *Driver) ImportOCILayout(dir string) (ids []string, err error) {
+	var index ociIndex
+	if err := readOCIJSON(filepath.Join(dir, "index.json"), &index); err != nil {
+		return nil, err
+	}
+	if len(index.Manifests) == 0 {
+		return nil, fmt.Errorf("aufs: no manifest in OCI layout %s", dir)
+	}
+	var manifest ociManifest
+	p, err := ociBlobPath(dir, index.Manifests[0].Digest)
+	if err != nil {
+		return nil, err
+	}
+	if err := readOCIJSON(p, &manifest); err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		// Children first, so no layer is removed from under another
+		for i := len(ids) - 1; i >= 0; i-- {
+			a.Remove(ids[i])
+		}
+		ids = nil
+	}()
+
+	var parent string
+	for _, desc := range manifest.Layers {
+		if desc.MediaType != ociMediaTypeLayer && desc.MediaType != ociMediaTypeLayerGz {
+			return ids, fmt.Errorf("aufs: unsupported OCI layer media type %s", desc.MediaType)
+		}
+		id := stringid.GenerateRandomID()
+		if err := a.Create(id, parent); err != nil {
+			return ids, err
+		}
+		ids = append(ids, id)
+		if err := a.importOCIBlob(dir, id, desc); err != nil {
+			return ids, fmt.Errorf("aufs: importing %s: %v", desc.Digest, err)
+		}
+		parent = id
+	}
+	return ids, nil
+}
+
+func (a *Driver) importOCIBlob(dir, id string, desc ociDescriptor) error {
+	p, err := ociBlobPath(dir, desc.Digest)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	defer a.use(id)()
+
+	// The blob digest covers the blob as stored, which may be compressed.
+	// The layer is recorded under its diff id, the digest of the tar, so
+	// that it matches what ApplyDiff records for the same content.
+	var (
+		blobHash = sha256.New()
+		diffHash = sha256.New()
+	)
+	tar, err := archive.DecompressStream(io.TeeReader(f, blobHash))
+	if err != nil {
+		return err
+	}
+	defer tar.Close()
+	if err := a.applyDiff(id, io.TeeReader(tar, diffHash)); err != nil {
+		return err
+	}
+	// Untar may stop at the end-of-archive marker, hash the padding too
+	if _, err := io.Copy(diffHash, tar); err != nil {
+		return err
+	}
+	if _, err := io.Copy(blobHash, f); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(blobHash.Sum(nil)); actual != desc.Digest {
+		return fmt.Errorf("digest mismatch: got %s", actual)
+	}
+
+	// Record the layer like ApplyDiff does
+	size, err := a.DiffSize(id, "")
+	if err != nil {
+		return err
+	}
+	_, parents, err := readChain(a.rootPath(), id)
+	if err != nil {
+		return err
+	}
+	self := chainEntry{id: id, digest: "sha256:" + hex.EncodeToString(diffHash.Sum(nil)), size: size}
+	if self.contentDigest, self.contentSize, err = a.contentDigest(id); err != nil {
+		return err
+	}
+	if err := a.writeChain(self, parents); err != nil {
+		return err
+	}
+	return a.syncLayer(id)
+}
+
+func ociBlobPath(dir, digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || parts[0] != "sha256" || strings.ContainsAny(parts[1], "/.") {
+		return "", fmt.Errorf("aufs: unsupported OCI digest %q", digest)
+	}
+	return filepath.Join(dir, "blobs", parts[0], parts[1]), nil
+}
+
+// writeOCIBlob stores the content of r as a blob of the layout in dir and
+// returns its descriptor.
+func writeOCIBlob(dir, mediaType string, r io.Reader) (ociDescriptor, error) {
+	tmp, err := ioutil.TempFile(filepath.Join(dir, "blobs"), "tmp-")
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+
+	hexDigest := hex.EncodeToString(h.Sum(nil))
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, "blobs", "sha256", hexDigest)); err != nil {
+		return ociDescriptor{}, err
+	}
+	return ociDescriptor{MediaType: mediaType, Digest: "sha256:" + hexDigest, Size: size}, nil
+}
+
+func writeOCIJSON(dir, mediaType string, v interface{}) (ociDescriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return ociDescriptor{}, err
+	}
+	return writeOCIBlob(dir, mediaType, bytes.NewReader(b))
+}
+
+func readOCIJSON(p string, v interface{}) error {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,205 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/parsers"
+)
+
+// profiles are named sets of options suited to a kind of deployment,
+// selected with aufs.profile. Options given explicitly take precedence.
+var profiles = map[string][]string{
+	// Short-lived builds: throughput over crash safety
+	"ci": {
+		"aufs.durability=none",
+		"aufs.createpool=16",
+		"aufs.mountidletimeout=5m",
+		"aufs.mounttimeout=1m",
+	},
+	// Small hosts that lose power: crash safety over throughput
+	"edge": {
+		"aufs.durability=full",
+		"aufs.mountidletimeout=10m",
+		"aufs.mounttimeout=2m",
+		"aufs.verifyremove=true",
+	},
+	"datacenter": {
+		"aufs.durability=metadata",
+		"aufs.createpool=8",
+		"aufs.mountidletimeout=30m",
+		"aufs.mounttimeout=5m",
+		"aufs.verifyremove=true",
+	},
+}
+
+type aufsOptions struct {
+	// profile is the name of the profile the options started from.
+	profile string
+	// mountIdleTimeout is how long a mountpoint may stay mounted without
+	// any reference before the reaper unmounts it. Zero disables the reaper.
+	mountIdleTimeout time.Duration
+	// durability is the fsync policy applied before a layer is complete.
+	durability durability
+	// createPool is the number of pre-created directory pairs kept
+	// around for Create to claim.
+	createPool int
+	// maxBranches and maxMounts bound the number of branches of a single
+	// mount and the number of mounts. Zero means the aufs default for
+	// branches and no limit for mounts.
+	maxBranches int
+	maxMounts   int
+	// verifyRemove makes Remove refuse layers other layers depend on.
+	verifyRemove bool
+	// hook, if set, is consulted before layers are created or mounted.
+	hook *admissionHook
+	// mountTimeout is how long a mount or unmount may take before the
+	// layer is given up on. Zero waits forever.
+	mountTimeout time.Duration
+	// sharded makes new layers use the sharded directory layout.
+	sharded bool
+	// metricsFile, if set, is where a snapshot of the status is written
+	// every metricsInterval.
+	metricsFile     string
+	metricsInterval time.Duration
+	// whiteouts is the format of the whiteouts of the archives of Diff.
+	whiteouts archive.WhiteoutFormat
+}
+
+func parseOptions(opt []string) (aufsOptions, error) {
+	var options aufsOptions
+
+	// The options of the profile come first, so explicit ones override them
+	for _, option := range opt {
+		key, val, err := parsers.ParseKeyValueOpt(option)
+		if err != nil || strings.ToLower(key) != "aufs.profile" {
+			continue
+		}
+		name := strings.ToLower(val)
+		if _, ok := profiles[name]; !ok {
+			return options, fmt.Errorf("Unknown profile %q for %s", val, key)
+		}
+		if options.profile != "" && options.profile != name {
+			return options, fmt.Errorf("Conflicting profiles %s and %s", options.profile, name)
+		}
+		options.profile = name
+	}
+	expanded := append(append([]string{}, profiles[options.profile]...), opt...)
+
+	for _, option := range expanded {
+		key, val, err := parsers.ParseKeyValueOpt(option)
+		if err != nil {
+			return options, err
+		}
+		key = strings.ToLower(key)
+		switch key {
+		case "aufs.mountidletimeout":
+			options.mountIdleTimeout, err = time.ParseDuration(val)
+			if err != nil {
+				return options, fmt.Errorf("Invalid value %q for %s: %v", val, key, err)
+			}
+			if options.mountIdleTimeout < 0 {
+				return options, fmt.Errorf("Invalid value %q for %s: must not be negative", val, key)
+			}
+		case "aufs.profile":
+			// Expanded above
+		case "aufs.mounttimeout":
+			options.mountTimeout, err = time.ParseDuration(val)
+			if err != nil || options.mountTimeout < 0 {
+				return options, fmt.Errorf("Invalid value %q for %s", val, key)
+			}
+		case "aufs.layout":
+			switch strings.ToLower(val) {
+			case "flat":
+				options.sharded = false
+			case "sharded":
+				options.sharded = true
+			default:
+				return options, fmt.Errorf("Invalid value %q for %s: must be flat or sharded", val, key)
+			}
+		case "aufs.metricsfile":
+			if !filepath.IsAbs(val) {
+				return options, fmt.Errorf("Invalid value %q for %s: must be an absolute path", val, key)
+			}
+			options.metricsFile = val
+		case "aufs.metricsinterval":
+			options.metricsInterval, err = time.ParseDuration(val)
+			if err != nil || options.metricsInterval <= 0 {
+				return options, fmt.Errorf("Invalid value %q for %s", val, key)
+			}
+		case "aufs.whiteouts":
+			switch strings.ToLower(val) {
+			case "aufs":
+				options.whiteouts = archive.AUFSWhiteoutFormat
+			case "oci":
+				options.whiteouts = archive.OCIWhiteoutFormat
+			default:
+				return options, fmt.Errorf("Invalid value %q for %s: must be aufs or oci", val, key)
+			}
+		case "aufs.durability":
+			options.durability, err = parseDurability(strings.ToLower(val))
+			if err != nil {
+				return options, err
+			}
+		case "aufs.createpool":
+			options.createPool, err = strconv.Atoi(val)
+			if err != nil || options.createPool < 0 {
+				return options, fmt.Errorf("Invalid value %q for %s", val, key)
+			}
+		case "aufs.maxbranches":
+			options.maxBranches, err = strconv.Atoi(val)
+			if err != nil || options.maxBranches < 2 {
+				return options, fmt.Errorf("Invalid value %q for %s", val, key)
+			}
+		case "aufs.maxmounts":
+			options.maxMounts, err = strconv.Atoi(val)
+			if err != nil || options.maxMounts < 0 {
+				return options, fmt.Errorf("Invalid value %q for %s", val, key)
+			}
+		case "aufs.verifyremove":
+			options.verifyRemove, err = strconv.ParseBool(val)
+			if err != nil {
+				return options, fmt.Errorf("Invalid value %q for %s: %v", val, key, err)
+			}
+		case "aufs.hook":
+			options.hookOrDefault().path = val
+		case "aufs.hooktimeout":
+			timeout, err := time.ParseDuration(val)
+			if err != nil || timeout <= 0 {
+				return options, fmt.Errorf("Invalid value %q for %s", val, key)
+			}
+			options.hookOrDefault().timeout = timeout
+		case "aufs.hookfailopen":
+			failOpen, err := strconv.ParseBool(val)
+			if err != nil {
+				return options, fmt.Errorf("Invalid value %q for %s: %v", val, key, err)
+			}
+			options.hookOrDefault().failOpen = failOpen
+		default:
+			return options, fmt.Errorf("Unknown option %s", key)
+		}
+	}
+	if options.metricsInterval > 0 && options.metricsFile == "" {
+		return options, fmt.Errorf("aufs.metricsinterval requires aufs.metricsfile")
+	}
+	if options.metricsInterval == 0 {
+		options.metricsInterval = defaultMetricsInterval
+	}
+	if options.hook != nil && options.hook.path == "" {
+		return options, fmt.Errorf("aufs.hooktimeout and aufs.hookfailopen require aufs.hook")
+	}
+	return options, nil
+}
+
+func (o *aufsOptions) hookOrDefault() *admissionHook {
+	if o.hook == nil {
+		o.hook = &admissionHook{timeout: defaultHookTimeout}
+	}
+	return o.hook
+}
@@ -0,0 +1,41 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// PinnedAnnotation marks a layer that must not be removed, such as the
+// base images a host depends on, until it is unpinned.
+const PinnedAnnotation = "aufs.pinned"
+
+// ErrLayerPinned is returned when removing a pinned layer.
+type ErrLayerPinned struct {
+	ID string
+}
+
+func (e ErrLayerPinned) Error() string {
+	return fmt.Sprintf("aufs: cannot remove %s: layer is pinned", stringid.TruncateID(e.ID))
+}
+
+// Pin protects the layer with the given id from removal.
+func (a *Driver) Pin(id string) error {
+	return a.Annotate(id, map[string]string{PinnedAnnotation: "true"})
+}
+
+// Unpin lifts the protection of the layer with the given id.
+func (a *Driver) Unpin(id string) error {
+	return a.Annotate(id, map[string]string{PinnedAnnotation: ""})
+}
+
+func (a *Driver) isPinned(id string) (bool, error) {
+	annotations, err := readAnnotations(a.annotationsPath(id))
+	if err != nil {
+		return false, err
+	}
+	_, pinned := annotations[PinnedAnnotation]
+	return pinned, nil
+}
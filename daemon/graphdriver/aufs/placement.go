@@ -0,0 +1,58 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/daemon/graphdriver"
+	mountpk "github.com/docker/docker/pkg/mount"
+)
+
+// Placement returns where the diff directory of the layer with the given
+// id is stored.
+func (a *Driver) Placement(id string) (*graphdriver.Placement, error) {
+	if !a.Exists(id) {
+		return nil, fmt.Errorf("aufs: unknown layer %s", id)
+	}
+	p := a.layerPath("diff", id)
+	var st syscall.Stat_t
+	if err := syscall.Stat(p, &st); err != nil {
+		return nil, err
+	}
+	placement := &graphdriver.Placement{
+		Path:   p,
+		Device: fmt.Sprintf("%d:%d", major(st.Dev), minor(st.Dev)),
+	}
+
+	mounts, err := mountpk.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	// The filesystem holding p is the deepest mount of its device above it
+	for _, m := range mounts {
+		if fmt.Sprintf("%d:%d", m.Major, m.Minor) != placement.Device || !isUnder(p, m.Mountpoint) {
+			continue
+		}
+		if len(m.Mountpoint) >= len(placement.Mountpoint) {
+			placement.Mountpoint = m.Mountpoint
+			placement.Fstype = m.Fstype
+			placement.Source = m.Source
+		}
+	}
+	return placement, nil
+}
+
+func isUnder(p, dir string) bool {
+	return dir == "/" || p == dir || strings.HasPrefix(p, dir+"/")
+}
+
+func major(device uint64) uint64 {
+	return (device >> 8) & 0xfff
+}
+
+func minor(device uint64) uint64 {
+	return (device & 0xff) | ((device >> 12) & 0xfff00)
+}
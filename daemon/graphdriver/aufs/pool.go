@@ -0,0 +1,121 @@
+// +build linux
+
+package aufs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// poolPrefix marks the pre-created directories waiting in mnt/ and diff/
+// to be claimed by Create.
+const poolPrefix = ".pool-"
+
+// dirPool keeps a number of empty mnt/diff directory pairs around so that
+// Create only has to rename them into place, with the mkdirs done in the
+// background.
+type dirPool struct {
+	sync.Mutex
+	root      string
+	size      int
+	free      []string
+	refilling bool
+}
+
+func newDirPool(root string, size int) (*dirPool, error) {
+	p := &dirPool{root: root, size: size}
+
+	// Reuse what a previous run left behind
+	entries, err := ioutil.ReadDir(path.Join(root, "diff"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if !strings.HasPrefix(name, poolPrefix) {
+			continue
+		}
+		if _, err := os.Lstat(path.Join(root, "mnt", name)); err != nil {
+			os.RemoveAll(path.Join(root, "diff", name))
+			continue
+		}
+		p.free = append(p.free, name)
+	}
+	p.refill()
+	return p, nil
+}
+
+// claim moves a pooled directory pair to mnt and diff. It returns false
+// if the pool is empty or the pair could not be used, in which case the
+// caller creates the directories itself.
+func (p *dirPool) claim(mnt, diff string) bool {
+	p.Lock()
+	if len(p.free) == 0 {
+		p.Unlock()
+		return false
+	}
+	name := p.free[len(p.free)-1]
+	p.free = p.free[:len(p.free)-1]
+	p.Unlock()
+	defer p.refill()
+
+	for _, move := range [][2]string{{"mnt", mnt}, {"diff", diff}} {
+		if err := os.Rename(path.Join(p.root, move[0], name), move[1]); err != nil {
+			logrus.Debugf("aufs: cannot use pooled directories %s: %v", name, err)
+			os.RemoveAll(path.Join(p.root, "mnt", name))
+			os.RemoveAll(path.Join(p.root, "diff", name))
+			return false
+		}
+	}
+	return true
+}
+
+// refill tops the pool up in the background.
+func (p *dirPool) refill() {
+	p.Lock()
+	if p.refilling || len(p.free) >= p.size {
+		p.Unlock()
+		return
+	}
+	p.refilling = true
+	p.Unlock()
+
+	go func() {
+		for {
+			p.Lock()
+			if len(p.free) >= p.size {
+				p.refilling = false
+				p.Unlock()
+				return
+			}
+			p.Unlock()
+
+			name := poolPrefix + stringid.GenerateRandomID()[:12]
+			if err := p.create(name); err != nil {
+				logrus.Errorf("aufs: refilling directory pool: %v", err)
+				p.Lock()
+				p.refilling = false
+				p.Unlock()
+				return
+			}
+			p.Lock()
+			p.free = append(p.free, name)
+			p.Unlock()
+		}
+	}()
+}
+
+func (p *dirPool) create(name string) error {
+	for _, dir := range []string{"mnt", "diff"} {
+		if err := os.Mkdir(path.Join(p.root, dir, name), 0755); err != nil {
+			return err
+		}
+	}
+	return nil
+}
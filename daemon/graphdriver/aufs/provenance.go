@@ -0,0 +1,144 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/archive"
+)
+
+// maxSymlinks bounds the symbolic links followed resolving a path, like
+// the kernel does.
+const maxSymlinks = 40
+
+// Provenance returns the id of the layer that provides the file at p, an
+// absolute path inside the filesystem of the layer with the given id, by
+// walking the chain from the top down the way aufs does: whiteouts hide
+// the file from lower layers and opaque directories hide everything below
+// them. The path is resolved one component at a time, symbolic links on
+// the way are followed inside the filesystem of the layer, never on the
+// host, and the last component is not followed. A file that is not
+// visible yields an error satisfying os.IsNotExist.
+func (a *Driver) Provenance(id, p string) (string, error) {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return "", fmt.Errorf("aufs: provenance of the root directory is not defined")
+	}
+	parents, err := getParentIds(a.rootPath(), id)
+	if err != nil {
+		return "", err
+	}
+	chain := append([]string{id}, parents...)
+	defer a.use(chain...)()
+
+	notExist := &os.PathError{Op: "provenance", Path: p, Err: os.ErrNotExist}
+	links := 0
+	components := strings.Split(p[1:], "/")
+	// layers are the ones whose directory at dir is part of the union,
+	// from the top down
+	dir, layers := "/", chain
+	for len(components) > 0 {
+		c := components[0]
+		components = components[1:]
+		cur := path.Join(dir, c)
+
+		found, fi, below, err := a.lookupBranch(layers, dir, c)
+		if err != nil {
+			return "", err
+		}
+		if found == "" {
+			return "", notExist
+		}
+		if len(components) == 0 {
+			return found, nil
+		}
+
+		switch {
+		case fi.Mode()&os.ModeSymlink != 0:
+			if links++; links > maxSymlinks {
+				return "", &os.PathError{Op: "provenance", Path: p, Err: syscall.ELOOP}
+			}
+			target, err := os.Readlink(path.Join(a.layerPath("diff", found), cur))
+			if err != nil {
+				return "", err
+			}
+			// Absolute targets are relative to the root of the layer
+			if !path.IsAbs(target) {
+				target = path.Join(dir, target)
+			}
+			target = path.Clean("/" + target)
+			components = append(splitPath(target), components...)
+			dir, layers = "/", chain
+		case fi.IsDir():
+			dir, layers = cur, below
+		default:
+			// Nothing is visible under a file
+			return "", notExist
+		}
+	}
+	return "", notExist
+}
+
+// lookupBranch finds the topmost of layers that holds name in dir, where
+// dir is a directory in each of layers, so no symbolic link is followed.
+// It returns the layer, what it holds and, if that is a directory, the
+// layers whose directory at dir/name is part of the union from there on.
+func (a *Driver) lookupBranch(layers []string, dir, name string) (string, os.FileInfo, []string, error) {
+	for i, layer := range layers {
+		root := a.layerPath("diff", layer)
+		fi, err := os.Lstat(path.Join(root, dir, name))
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return "", nil, nil, err
+			}
+			if exists(path.Join(root, dir, archive.WhiteoutPrefix+name)) {
+				return "", nil, nil, nil
+			}
+			continue
+		}
+		if !fi.IsDir() {
+			return layer, fi, nil, nil
+		}
+		var below []string
+		for _, l := range layers[i:] {
+			lroot := a.layerPath("diff", l)
+			if l != layer {
+				if exists(path.Join(lroot, dir, archive.WhiteoutPrefix+name)) {
+					break
+				}
+				lfi, err := os.Lstat(path.Join(lroot, dir, name))
+				if err != nil || !lfi.IsDir() {
+					// A missing directory does not stop the merge, anything
+					// else hides the layers below
+					if err != nil && os.IsNotExist(err) {
+						continue
+					}
+					break
+				}
+			}
+			below = append(below, l)
+			if exists(path.Join(lroot, dir, name, archive.WhiteoutOpaqueDir)) {
+				break
+			}
+		}
+		return layer, fi, below, nil
+	}
+	return "", nil, nil, nil
+}
+
+func splitPath(p string) []string {
+	if p == "/" {
+		return nil
+	}
+	return strings.Split(p[1:], "/")
+}
+
+func exists(p string) bool {
+	_, err := os.Lstat(p)
+	return err == nil
+}
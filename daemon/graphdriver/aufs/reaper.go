@@ -0,0 +1,100 @@
+// +build linux
+
+package aufs
+
+import (
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	mountpk "github.com/docker/docker/pkg/mount"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// startReaper runs the idle-mount reaper in the background until Cleanup
+// is called. Mounts can be left behind with no reference, e.g. when the
+// unmount in Put fails or after a daemon crash, and would otherwise stay
+// around until the next reboot.
+func (a *Driver) startReaper() {
+	a.stopReaper = make(chan struct{})
+	interval := a.options.mountIdleTimeout / 2
+	if interval < time.Second {
+		interval = time.Second
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				if err := a.reapIdleMounts(now); err != nil {
+					logrus.Errorf("aufs: reaping idle mounts: %v", err)
+				}
+			case <-a.stopReaper:
+				return
+			}
+		}
+	}()
+}
+
+// reapIdleMounts unmounts the mountpoints under mnt/ that have had no
+// reference for longer than the idle timeout.
+func (a *Driver) reapIdleMounts(now time.Time) error {
+	mounts, err := mountpk.GetMounts()
+	if err != nil {
+		return err
+	}
+	prefix := path.Join(a.rootPath(), "mnt") + "/"
+	var mounted []string
+	for _, m := range mounts {
+		if strings.HasPrefix(m.Mountpoint, prefix) {
+			mounted = append(mounted, path.Base(m.Mountpoint))
+		}
+	}
+
+	a.Lock()
+	defer a.Unlock()
+
+	for _, id := range a.idleMounts(mounted, now) {
+		logrus.Debugf("aufs: unmounting %s, idle for more than %s", stringid.TruncateID(id), a.options.mountIdleTimeout)
+		if err := a.unmount(id); err != nil {
+			logrus.Errorf("aufs: unmounting idle %s: %v", stringid.TruncateID(id), err)
+			continue
+		}
+		delete(a.idleSince, id)
+	}
+	return nil
+}
+
+// idleMounts reconciles the mounted ids with the active references and
+// returns the ones that have been idle long enough to be unmounted.
+// Must be called with the driver lock held.
+func (a *Driver) idleMounts(mounted []string, now time.Time) []string {
+	var (
+		expired []string
+		seen    = make(map[string]struct{}, len(mounted))
+	)
+	for _, id := range mounted {
+		seen[id] = struct{}{}
+		if a.active[id] != 0 {
+			delete(a.idleSince, id)
+			continue
+		}
+		since, ok := a.idleSince[id]
+		if !ok {
+			a.idleSince[id] = now
+			continue
+		}
+		if now.Sub(since) >= a.options.mountIdleTimeout {
+			expired = append(expired, id)
+		}
+	}
+	// Forget about the ones that got unmounted in the meantime
+	for id := range a.idleSince {
+		if _, ok := seen[id]; !ok {
+			delete(a.idleSince, id)
+		}
+	}
+	return expired
+}
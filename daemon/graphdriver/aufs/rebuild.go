@@ -0,0 +1,123 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+)
+
+// ParentHints collects the parent of every image and container layer known
+// to the docker instance rooted at pth, from the image json files under
+// graph/ and the container config.json files under containers/. A
+// container's rw layer sits on its -init layer, which sits on the image.
+// Entries that cannot be read are skipped, the result is only a hint for
+// RebuildMetadata.
+func ParentHints(pth string) (map[string]string, error) {
+	hints := make(map[string]string)
+
+	fis, err := ioutil.ReadDir(path.Join(pth, "graph"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		if m, err := loadMetadata(path.Join(pth, "graph", fi.Name(), "json")); err == nil && m.ID == fi.Name() {
+			hints[m.ID] = m.ParentID
+		}
+	}
+
+	fis, err = ioutil.ReadDir(path.Join(pth, "containers"))
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range fis {
+		if m, err := loadMetadata(path.Join(pth, "containers", fi.Name(), "config.json")); err == nil && m.ID == fi.Name() {
+			initID := fmt.Sprintf("%s-init", m.ID)
+			hints[initID] = m.Image
+			hints[m.ID] = initID
+		}
+	}
+	return hints, nil
+}
+
+// RebuildMetadata recreates the missing layers files of the layers whose
+// diff directory survived, using hints, which maps a layer id to the id of
+// its parent ("" for a base layer), for example as returned by
+// ParentHints. Layers that already have a layers file are left alone and
+// are trusted as parents. It returns the ids it rebuilt and the ids it
+// could not, because no hint was found for them or for one of their
+// parents, or because their parent has no diff directory.
+func (a *Driver) RebuildMetadata(hints map[string]string) (rebuilt, unresolved []string, err error) {
+	ids, err := a.diffIds()
+	if err != nil {
+		return nil, nil, err
+	}
+	var (
+		chains = make(map[string][]string)
+		failed = make(map[string]bool)
+	)
+	// resolve returns the chain of id, closest parent first, or false if
+	// it cannot be determined.
+	var resolve func(id string, seen map[string]bool) ([]string, bool)
+	resolve = func(id string, seen map[string]bool) ([]string, bool) {
+		if chain, ok := chains[id]; ok {
+			return chain, true
+		}
+		if failed[id] || seen[id] || !pathExists(a.layerPath("diff", id)) {
+			return nil, false
+		}
+		if a.Exists(id) {
+			chain, err := getParentIds(a.rootPath(), id)
+			if err != nil {
+				failed[id] = true
+				return nil, false
+			}
+			chains[id] = chain
+			return chain, true
+		}
+		parent, ok := hints[id]
+		if !ok {
+			failed[id] = true
+			return nil, false
+		}
+		var chain []string
+		if parent != "" {
+			seen[id] = true
+			tail, ok := resolve(parent, seen)
+			delete(seen, id)
+			if !ok {
+				failed[id] = true
+				return nil, false
+			}
+			chain = append([]string{parent}, tail...)
+		}
+		mnt, err := a.createLayerPath("mnt", id)
+		if err == nil {
+			err = os.MkdirAll(mnt, 0755)
+		}
+		if err != nil {
+			failed[id] = true
+			return nil, false
+		}
+		if err := a.writeParentIds(id, chain); err != nil {
+			failed[id] = true
+			return nil, false
+		}
+		chains[id] = chain
+		rebuilt = append(rebuilt, id)
+		return chain, true
+	}
+
+	for _, id := range ids {
+		if a.Exists(id) {
+			continue
+		}
+		if _, ok := resolve(id, make(map[string]bool)); !ok {
+			unresolved = append(unresolved, id)
+		}
+	}
+	a.countLayers(len(rebuilt))
+	return rebuilt, unresolved, nil
+}
@@ -0,0 +1,71 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"path"
+)
+
+// ReplaceParent rewrites the chain of every layer built on top of the
+// layer old so that it goes through replacement, and what replacement
+// sits on, instead. It is meant to make layers mountable again when a
+// parent went missing but an identical copy of it exists under another
+// id, so it refuses to replace a layer that still exists, and a layer
+// whose digest is recorded by one of its children and differs from the
+// digest of replacement. Layers whose digests are unknown are trusted to
+// be identical. It returns the ids of the layers that were rewritten.
+func (a *Driver) ReplaceParent(old, replacement string) ([]string, error) {
+	if a.Exists(old) {
+		return nil, fmt.Errorf("aufs: layer %s still exists", old)
+	}
+	if !a.Exists(replacement) {
+		return nil, fmt.Errorf("aufs: replacement layer %s does not exist", replacement)
+	}
+	head, tail, err := readChain(a.rootPath(), replacement)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range tail {
+		if p.id == old {
+			return nil, fmt.Errorf("aufs: replacement layer %s is built on %s", replacement, old)
+		}
+	}
+
+	ids, err := loadIds(path.Join(a.rootPath(), "layers"))
+	if err != nil {
+		return nil, err
+	}
+	// Everything is checked before the first layers file is rewritten
+	type rewrite struct {
+		self  chainEntry
+		chain []chainEntry
+	}
+	var rewrites []rewrite
+	for _, id := range ids {
+		self, parents, err := readChain(a.rootPath(), id)
+		if err != nil {
+			return nil, err
+		}
+		for i, p := range parents {
+			if p.id != old {
+				continue
+			}
+			if p.digest != "" && head.digest != "" && p.digest != head.digest {
+				return nil, fmt.Errorf("aufs: %s records %s as %s, replacement layer %s is %s", id, old, p.digest, replacement, head.digest)
+			}
+			chain := append(append(parents[:i:i], head), tail...)
+			rewrites = append(rewrites, rewrite{self, chain})
+			break
+		}
+	}
+
+	var rewritten []string
+	for _, r := range rewrites {
+		if err := a.writeChain(r.self, r.chain); err != nil {
+			return rewritten, err
+		}
+		rewritten = append(rewritten, r.self.id)
+	}
+	return rewritten, nil
+}
@@ -0,0 +1,67 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// RetiredAnnotation marks a layer as retired: no new layer may be created
+// on top of it or of its descendants, while the existing ones keep
+// working. Its value names the image to use instead, if any.
+const RetiredAnnotation = "aufs.retired"
+
+// ErrLayerRetired is returned by Create when the parent chain of the new
+// layer goes through a retired layer.
+type ErrLayerRetired struct {
+	ID          string
+	Retired     string
+	Replacement string
+}
+
+func (e ErrLayerRetired) Error() string {
+	msg := fmt.Sprintf("aufs: cannot create %s: layer %s is retired", stringid.TruncateID(e.ID), stringid.TruncateID(e.Retired))
+	if e.Replacement != "" {
+		msg += fmt.Sprintf(", use %s instead", e.Replacement)
+	}
+	return msg
+}
+
+// Retire marks the layer with the given id as retired, optionally naming
+// the image that replaces it.
+func (a *Driver) Retire(id, replacement string) error {
+	if replacement == "" {
+		replacement = "true"
+	}
+	return a.Annotate(id, map[string]string{RetiredAnnotation: replacement})
+}
+
+// Unretire lifts the retirement of the layer with the given id.
+func (a *Driver) Unretire(id string) error {
+	return a.Annotate(id, map[string]string{RetiredAnnotation: ""})
+}
+
+// checkRetired fails if parent or one of its parents is retired.
+func (a *Driver) checkRetired(id, parent string) error {
+	parents, err := getParentIds(a.rootPath(), parent)
+	if err != nil {
+		return err
+	}
+	for _, layer := range append([]string{parent}, parents...) {
+		annotations, err := readAnnotations(a.annotationsPath(layer))
+		if err != nil {
+			return err
+		}
+		replacement, retired := annotations[RetiredAnnotation]
+		if !retired {
+			continue
+		}
+		if replacement == "true" {
+			replacement = ""
+		}
+		return ErrLayerRetired{ID: id, Retired: layer, Replacement: replacement}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// +build linux
+
+package aufs
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+)
+
+// Scavenge deletes what a crash can leave behind in diff/ and mnt/: the
+// directories Remove moved out of the way but did not get to delete and,
+// if orphans is set, the directories of layers whose metadata is gone. It
+// returns the paths it deleted.
+//
+// Init only deletes the former: the content of an orphan may still be
+// recovered by RebuildMetadata. Even when asked to, a root with
+// directories but no metadata at all is more likely to have lost its
+// layers/ than to be full of orphans, so its directories are left alone.
+func (a *Driver) Scavenge(orphans bool) ([]string, error) {
+	ids, err := loadIds(path.Join(a.rootPath(), "layers"))
+	if err != nil {
+		return nil, err
+	}
+	keepOrphans := !orphans || len(ids) == 0
+
+	var removed []string
+	for _, kind := range []string{"diff", "mnt"} {
+		var walk func(dir string, top bool) error
+		walk = func(dir string, top bool) error {
+			fis, err := ioutil.ReadDir(dir)
+			if err != nil {
+				return err
+			}
+			for _, fi := range fis {
+				name := fi.Name()
+				p := path.Join(dir, name)
+				switch {
+				case !fi.IsDir(), strings.HasPrefix(name, poolPrefix):
+				case strings.HasSuffix(name, "-removing"):
+					// Moved away by Remove, hence no longer mounted
+					if err := os.RemoveAll(p); err != nil {
+						return err
+					}
+					removed = append(removed, p)
+				case top && isShardDir(name):
+					if err := walk(p, false); err != nil {
+						return err
+					}
+				case keepOrphans || pathExists(a.layerPath("layers", name)):
+				case kind == "mnt":
+					// Never delete through a mountpoint that is still in use,
+					// an unused mountpoint is empty
+					if err := os.Remove(p); err != nil {
+						logrus.Warnf("aufs: leaving orphaned mountpoint %s: %v", p, err)
+						continue
+					}
+					removed = append(removed, p)
+				default:
+					if err := os.RemoveAll(p); err != nil {
+						return err
+					}
+					removed = append(removed, p)
+				}
+			}
+			return nil
+		}
+		if err := walk(path.Join(a.rootPath(), kind), true); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
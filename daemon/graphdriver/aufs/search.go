@@ -0,0 +1,66 @@
+// +build linux
+
+package aufs
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/pkg/archive"
+)
+
+// Search looks for files matching pattern in the diff directories of all
+// the layers and returns, per layer id, the matching paths relative to the
+// layer root. A pattern containing a slash is matched against the whole
+// path (e.g. "/usr/lib/libssl.so.*"), otherwise against the file name
+// only. Whiteouts and aufs metadata are never reported.
+func (a *Driver) Search(pattern string) (map[string][]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	wholePath := strings.Contains(pattern, "/")
+
+	ids, err := loadIds(path.Join(a.rootPath(), "layers"))
+	if err != nil {
+		return nil, err
+	}
+	found := make(map[string][]string)
+	for _, id := range ids {
+		release := a.use(id)
+		root := a.layerPath("diff", id)
+		err := filepath.Walk(root, func(p string, fi os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if p == root {
+				return nil
+			}
+			name := fi.Name()
+			if strings.HasPrefix(name, archive.WhiteoutPrefix) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			rel := "/" + strings.TrimPrefix(p, root+"/")
+			subject := name
+			if wholePath {
+				subject = rel
+			}
+			if matched, _ := filepath.Match(pattern, subject); matched {
+				found[id] = append(found[id], rel)
+			}
+			return nil
+		})
+		release()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return found, nil
+}
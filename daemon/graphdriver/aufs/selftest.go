@@ -0,0 +1,164 @@
+// +build linux
+
+package aufs
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+
+	"github.com/docker/docker/daemon/graphdriver"
+)
+
+// SelfTest exercises the features the driver relies on against the
+// running kernel and the filesystem of the root, so that a host can be
+// checked before it takes production load. The checks that need aufs
+// are skipped when it is not available. Nothing is left behind.
+func (a *Driver) SelfTest() []graphdriver.SelfTestResult {
+	scratch, err := ioutil.TempDir(a.rootPath(), ".selftest-")
+	if err != nil {
+		return []graphdriver.SelfTestResult{{Name: "scratch directory", Detail: err.Error()}}
+	}
+	defer os.RemoveAll(scratch)
+
+	aufsErr := supportsAufs()
+	checks := []struct {
+		name      string
+		needsAufs bool
+		run       func(dir string) error
+	}{
+		{"aufs supported", false, func(string) error { return aufsErr }},
+		{"dirperm1", true, func(string) error {
+			if !useDirperm() {
+				return fmt.Errorf("dirperm1 is not supported, permissions of lower directories may be wrong")
+			}
+			return nil
+		}},
+		{"xino writable", false, checkXino},
+		{"rename on root", false, checkRename},
+		{"whiteouts", true, a.checkWhiteouts},
+		{"branch limit", true, a.checkBranchLimit},
+	}
+
+	results := make([]graphdriver.SelfTestResult, 0, len(checks))
+	for i, c := range checks {
+		result := graphdriver.SelfTestResult{Name: c.name}
+		if c.needsAufs && aufsErr != nil {
+			result.Skipped = true
+			result.Detail = "aufs is not supported"
+			results = append(results, result)
+			continue
+		}
+		dir := path.Join(scratch, fmt.Sprintf("%d", i))
+		if err := os.Mkdir(dir, 0755); err != nil {
+			result.Detail = err.Error()
+		} else if err := c.run(dir); err != nil {
+			result.Detail = err.Error()
+		} else {
+			result.Passed = true
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// checkXino verifies that the xino file used by every mount can be
+// created.
+func checkXino(string) error {
+	f, err := ioutil.TempFile("/dev/shm", "aufs.xino.selftest-")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// checkRename verifies that a rename replaces its target atomically on
+// the filesystem of the root, as writeChain and Remove rely on.
+func checkRename(dir string) error {
+	var (
+		src = path.Join(dir, "src")
+		dst = path.Join(dir, "dst")
+	)
+	if err := ioutil.WriteFile(src, []byte("new"), 0644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(dst, []byte("old"), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(dst)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(content, []byte("new")) {
+		return fmt.Errorf("rename did not replace its target")
+	}
+	if err := os.Mkdir(src, 0755); err != nil {
+		return err
+	}
+	return os.Rename(src, path.Join(dir, "renamed"))
+}
+
+// checkWhiteouts verifies that removing a file of a lower branch through
+// a mount leaves a whiteout in the rw branch.
+func (a *Driver) checkWhiteouts(dir string) error {
+	var (
+		lower = path.Join(dir, "lower")
+		upper = path.Join(dir, "upper")
+		union = path.Join(dir, "union")
+	)
+	for _, p := range []string{lower, upper, union} {
+		if err := os.Mkdir(p, 0755); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(lower, "file"), nil, 0644); err != nil {
+		return err
+	}
+	if err := a.aufsMount([]string{lower}, upper, union, ""); err != nil {
+		return err
+	}
+	defer Unmount(union)
+
+	if err := os.Remove(path.Join(union, "file")); err != nil {
+		return err
+	}
+	if _, err := os.Lstat(path.Join(upper, ".wh.file")); err != nil {
+		return fmt.Errorf("no whiteout in the rw branch: %v", err)
+	}
+	if _, err := os.Lstat(path.Join(lower, "file")); err != nil {
+		return fmt.Errorf("lower branch was modified: %v", err)
+	}
+	return nil
+}
+
+// checkBranchLimit verifies that a mount with as many branches as the
+// configured limit succeeds.
+func (a *Driver) checkBranchLimit(dir string) error {
+	var (
+		upper = path.Join(dir, "upper")
+		union = path.Join(dir, "union")
+		ro    []string
+	)
+	for _, p := range []string{upper, union} {
+		if err := os.Mkdir(p, 0755); err != nil {
+			return err
+		}
+	}
+	for i := 1; i < a.branchLimit(); i++ {
+		p := path.Join(dir, fmt.Sprintf("ro%d", i))
+		if err := os.Mkdir(p, 0755); err != nil {
+			return err
+		}
+		ro = append(ro, p)
+	}
+	if err := a.aufsMount(ro, upper, union, ""); err != nil {
+		return fmt.Errorf("mounting %d branches: %v", len(ro)+1, err)
+	}
+	return Unmount(union)
+}
@@ -0,0 +1,254 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// shardWidth is the length of the id prefix that names the shard
+// directory of a layer in the sharded layout, where the entries of a
+// layer are stored as diff/ab/abcdef..., mnt/ab/abcdef... and
+// layers/ab/abcdef... instead of directly under diff/, mnt/ and layers/.
+// Ids no longer than that are always stored flat.
+const shardWidth = 2
+
+// shardOf returns the shard directory of id, or "" if id is too short to
+// be sharded.
+func shardOf(id string) string {
+	if len(id) <= shardWidth {
+		return ""
+	}
+	return id[:shardWidth]
+}
+
+// isShardDir returns whether name is the name of a shard directory.
+func isShardDir(name string) bool {
+	if len(name) != shardWidth {
+		return false
+	}
+	for _, c := range name {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// layerPath returns where the kind ("diff", "mnt" or "layers") entry of
+// the layer id is stored. Both layouts are looked up, so that a store can
+// be migrated while in use; when the entry exists in neither, its flat
+// path is returned.
+func layerPath(root, kind, id string) string {
+	if shard := shardOf(id); shard != "" {
+		sharded := path.Join(root, kind, shard, id)
+		if _, err := os.Lstat(sharded); err == nil {
+			return sharded
+		}
+	}
+	return path.Join(root, kind, id)
+}
+
+// newLayerPath returns where to create the kind entry of a new layer id,
+// creating its shard directory if needed.
+func newLayerPath(root, kind, id string, sharded bool) (string, error) {
+	shard := shardOf(id)
+	if !sharded || shard == "" {
+		return path.Join(root, kind, id), nil
+	}
+	if err := os.MkdirAll(path.Join(root, kind, shard), 0755); err != nil {
+		return "", err
+	}
+	return path.Join(root, kind, shard, id), nil
+}
+
+func (a *Driver) layerPath(kind, id string) string {
+	return layerPath(a.rootPath(), kind, id)
+}
+
+// createLayerPath returns the existing kind entry of id, or where to
+// create it in the configured layout.
+func (a *Driver) createLayerPath(kind, id string) (string, error) {
+	if p := a.layerPath(kind, id); pathExists(p) {
+		return p, nil
+	}
+	return newLayerPath(a.rootPath(), kind, id, a.options.sharded)
+}
+
+// diffIds returns the names of the directories under diff/, in both
+// layouts, leaving out pooled and half-removed directories.
+func (a *Driver) diffIds() ([]string, error) {
+	var ids []string
+	var walk func(dir string, top bool) error
+	walk = func(dir string, top bool) error {
+		fis, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+		for _, fi := range fis {
+			name := fi.Name()
+			switch {
+			case !fi.IsDir(), strings.HasPrefix(name, poolPrefix), strings.HasSuffix(name, "-removing"):
+			case top && isShardDir(name):
+				if err := walk(path.Join(dir, name), false); err != nil {
+					return err
+				}
+			default:
+				ids = append(ids, name)
+			}
+		}
+		return nil
+	}
+	if err := walk(path.Join(a.rootPath(), "diff"), true); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// migrateBatch is the number of layers MigrateLayout moves per hold of
+// the driver lock.
+const migrateBatch = 64
+
+// MigrateLayout moves the layers of the store to the sharded layout, or
+// back to the flat one, while the driver is in use. Layers that are
+// mounted, referenced, exported, under a mounted layer or in use by
+// another operation are left where they are and can be moved by a later
+// call; both layouts keep working in the meantime. The driver lock is
+// only held for a batch of layers at a time. It returns the number of
+// layers moved.
+func (a *Driver) MigrateLayout(sharded bool) (int, error) {
+	ids, err := loadIds(path.Join(a.rootPath(), "layers"))
+	if err != nil {
+		return 0, err
+	}
+
+	var moved int
+	for len(ids) > 0 {
+		n := migrateBatch
+		if n > len(ids) {
+			n = len(ids)
+		}
+		m, err := a.migrateLayers(ids[:n], sharded)
+		moved += m
+		if err != nil {
+			return moved, err
+		}
+		ids = ids[n:]
+	}
+	logrus.Debugf("aufs: moved %d layers to the %s layout", moved, layoutName(sharded))
+	return moved, nil
+}
+
+// migrateLayers moves the layers in ids that are not busy.
+func (a *Driver) migrateLayers(ids []string, sharded bool) (int, error) {
+	a.Lock()
+	defer a.Unlock()
+	a.useLock.Lock()
+	defer a.useLock.Unlock()
+
+	busy := make(map[string]bool)
+	for id := range a.active {
+		busy[id] = true
+	}
+	for id := range a.using {
+		busy[id] = true
+	}
+	for _, id := range a.bindExports {
+		busy[id] = true
+	}
+	for id := range a.mountedBranches {
+		busy[id] = true
+		parents, err := getParentIds(a.rootPath(), id)
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range parents {
+			busy[p] = true
+		}
+	}
+
+	var moved int
+	for _, id := range ids {
+		if busy[id] || shardOf(id) == "" {
+			continue
+		}
+		if mounted, err := a.mounted(id); err != nil || mounted {
+			continue
+		}
+		changed, err := a.moveLayer(id, sharded)
+		if err != nil {
+			return moved, fmt.Errorf("aufs: moving %s: %v", stringid.TruncateID(id), err)
+		}
+		if changed {
+			moved++
+		}
+	}
+	return moved, nil
+}
+
+// moveLayer moves the entries of id to the given layout. The layers file
+// goes last, so an interrupted move leaves a layer that is still found.
+func (a *Driver) moveLayer(id string, sharded bool) (bool, error) {
+	var changed bool
+	for _, kind := range []string{"mnt", "diff", "layers"} {
+		current := a.layerPath(kind, id)
+		if _, err := os.Lstat(current); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return changed, err
+		}
+		target, err := newLayerPath(a.rootPath(), kind, id, sharded)
+		if err != nil {
+			return changed, err
+		}
+		if target == current {
+			continue
+		}
+		if err := os.Rename(current, target); err != nil {
+			return changed, err
+		}
+		changed = true
+		if !sharded {
+			// Fails as long as other layers are left in the shard
+			os.Remove(path.Dir(current))
+		}
+	}
+	if changed {
+		forgetParentIds(a.rootPath(), id)
+	}
+	return changed, nil
+}
+
+// layoutMismatch returns whether some layers are not stored in the
+// configured layout.
+func (a *Driver) layoutMismatch() bool {
+	fis, err := ioutil.ReadDir(path.Join(a.rootPath(), "layers"))
+	if err != nil {
+		return false
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if a.options.sharded && !fi.IsDir() && shardOf(name) != "" && !strings.HasSuffix(name, ".tmp") {
+			return true
+		}
+		if !a.options.sharded && fi.IsDir() && isShardDir(name) {
+			return true
+		}
+	}
+	return false
+}
+
+func layoutName(sharded bool) string {
+	if sharded {
+		return "sharded"
+	}
+	return "flat"
+}
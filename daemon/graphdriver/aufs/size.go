@@ -0,0 +1,74 @@
+// +build linux
+
+package aufs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/pkg/archive"
+	"github.com/docker/docker/pkg/directory"
+)
+
+// LayerSize breaks the size of a layer down into what the user wrote and
+// what aufs keeps alongside it.
+type LayerSize struct {
+	// Raw is the size of the diff directory, as returned by DiffSize.
+	Raw int64 `json:"raw"`
+	// Logical is the size of the files visible in the layer, leaving out
+	// whiteouts, opaque markers and the aufs metadata directories.
+	Logical int64 `json:"logical"`
+	// Whiteouts is the number of whiteouts and opaque markers.
+	Whiteouts int `json:"whiteouts"`
+}
+
+// LogicalSize returns the size of the layer with the given id, with the
+// data written by the user accounted separately from the raw size of its
+// diff directory.
+func (a *Driver) LogicalSize(id string) (*LayerSize, error) {
+	defer a.use(id)()
+	diff := a.layerPath("diff", id)
+	raw, err := directory.Size(diff)
+	if err != nil {
+		return nil, err
+	}
+	size := &LayerSize{Raw: raw}
+
+	seen := make(map[uint64]struct{})
+	err = filepath.Walk(diff, func(p string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch name := fi.Name(); {
+		case name == archive.WhiteoutOpaqueDir:
+			size.Whiteouts++
+			return nil
+		case strings.HasPrefix(name, archive.WhiteoutMetaPrefix):
+			// .wh..wh.plnk, .wh..wh.orph and the like
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		case strings.HasPrefix(name, archive.WhiteoutPrefix):
+			size.Whiteouts++
+			return nil
+		}
+		if fi.IsDir() || fi.Size() == 0 {
+			return nil
+		}
+		// Hard links are only counted once
+		ino := fi.Sys().(*syscall.Stat_t).Ino
+		if _, exists := seen[uint64(ino)]; exists {
+			return nil
+		}
+		seen[uint64(ino)] = struct{}{}
+		size.Logical += fi.Size()
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return size, nil
+}
@@ -0,0 +1,275 @@
+// +build linux
+
+package aufs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// stagingDir is where provisioning tools drop layers for the driver to
+// adopt, see ImportStaged.
+const stagingDir = "staging"
+
+// stagedID matches the ids staged layers may have, the ids of the graph.
+// Anything else could not be named in a branch option, and is most likely
+// a leftover of the tool that staged the layers.
+var stagedID = regexp.MustCompile(`^[a-f0-9]{64}$`)
+
+// ImportStaged adopts the layers staged under <root>/staging. Each layer
+// is a directory named after its id, holding the content of the layer,
+// with an optional <id>.parent file next to it containing the id of its
+// parent, which may itself be staged, and an optional <id>.json file with
+// the image json docker save writes for it. Entries whose name starts
+// with a dot are ignored, so a tool can prepare a layer as .<id> and
+// rename it once complete. Staged layers are validated like Adopt does
+// and moved into the store; the ones that cannot be adopted are left in
+// place and logged. It is run at Init and whenever the daemon receives
+// SIGUSR2, and returns the ids it adopted.
+//
+// Adopted layers are kept in ImportedLayers until the daemon registers
+// them as images and calls ReleaseImported, so that a layer adopted
+// before the daemon was ready is registered once it is.
+func (a *Driver) ImportStaged() ([]string, error) {
+	dir := path.Join(a.rootPath(), stagingDir)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	pending := make(map[string]string)
+	var ids []string
+	for _, fi := range fis {
+		id := fi.Name()
+		if !fi.IsDir() || strings.HasPrefix(id, ".") {
+			continue
+		}
+		if !stagedID.MatchString(id) {
+			logrus.Errorf("aufs: not importing staged layer %q: its name is not a layer id", id)
+			continue
+		}
+		if a.Exists(id) {
+			logrus.Errorf("aufs: not importing staged layer %s: it already exists", stringid.TruncateID(id))
+			continue
+		}
+		parent, err := ioutil.ReadFile(path.Join(dir, id+".parent"))
+		if err != nil && !os.IsNotExist(err) {
+			logrus.Errorf("aufs: not importing staged layer %s: %v", stringid.TruncateID(id), err)
+			continue
+		}
+		p := strings.TrimSpace(string(parent))
+		if p != "" && !stagedID.MatchString(p) {
+			logrus.Errorf("aufs: not importing staged layer %s: its parent %q is not a layer id", stringid.TruncateID(id), p)
+			continue
+		}
+		if p, err = stagedImageParent(dir, id, p); err != nil {
+			logrus.Errorf("aufs: not importing staged layer %s: %v", stringid.TruncateID(id), err)
+			continue
+		}
+		pending[id] = p
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	// Adopt parents before their children, until no progress is made
+	var adopted []string
+	for progress := true; progress; {
+		progress = false
+		for _, id := range ids {
+			parent, ok := pending[id]
+			if !ok {
+				continue
+			}
+			if _, staged := pending[parent]; staged {
+				continue
+			}
+			delete(pending, id)
+			progress = true
+			if err := a.adopt(id, path.Join(dir, id), parent); err != nil {
+				logrus.Errorf("aufs: importing staged layer %s: %v", stringid.TruncateID(id), err)
+				continue
+			}
+			if err := a.recordImported(id, path.Join(dir, id+".json")); err != nil {
+				logrus.Errorf("aufs: recording the import of %s: %v", stringid.TruncateID(id), err)
+			}
+			os.Remove(path.Join(dir, id+".parent"))
+			os.Remove(path.Join(dir, id+".json"))
+			adopted = append(adopted, id)
+		}
+	}
+	for id, parent := range pending {
+		logrus.Errorf("aufs: not importing staged layer %s: its chain through %s loops", stringid.TruncateID(id), stringid.TruncateID(parent))
+	}
+	if len(adopted) > 0 {
+		logrus.Infof("aufs: imported %d staged layers", len(adopted))
+		a.Lock()
+		hook := a.importHook
+		a.Unlock()
+		if hook != nil {
+			hook()
+		}
+	}
+	return adopted, nil
+}
+
+// stagedImageParent checks the image json staged for id, if any, against
+// the parent staged for it and returns the parent of the layer.
+func stagedImageParent(dir, id, parent string) (string, error) {
+	data, err := ioutil.ReadFile(path.Join(dir, id+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return parent, nil
+		}
+		return "", err
+	}
+	var img struct {
+		ID     string `json:"id"`
+		Parent string `json:"parent"`
+	}
+	if err := json.Unmarshal(data, &img); err != nil {
+		return "", fmt.Errorf("invalid image json: %v", err)
+	}
+	if img.ID != id {
+		return "", fmt.Errorf("its image json is for %q", img.ID)
+	}
+	if img.Parent != "" && !stagedID.MatchString(img.Parent) {
+		return "", fmt.Errorf("the parent %q of its image json is not a layer id", img.Parent)
+	}
+	if parent != "" && img.Parent != parent {
+		return "", fmt.Errorf("its image json is on %q, not on %s", img.Parent, parent)
+	}
+	return img.Parent, nil
+}
+
+// importedDir is where the driver keeps the layers it adopted from staging
+// until the daemon registers them, each in a file named after its id that
+// holds the image json staged with it, if any.
+const importedDir = "imported"
+
+// recordImported keeps the adopted layer with the given id in
+// ImportedLayers, along with the image json at imagePath if there is one.
+func (a *Driver) recordImported(id, imagePath string) error {
+	data, err := ioutil.ReadFile(imagePath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	dir := path.Join(a.rootPath(), importedDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp := path.Join(dir, "."+id)
+	if err := ioutil.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path.Join(dir, id))
+}
+
+// ImportedLayers returns the layers adopted by ImportStaged that were not
+// released yet, parents first.
+func (a *Driver) ImportedLayers() ([]graphdriver.ImportedLayer, error) {
+	dir := path.Join(a.rootPath(), importedDir)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var (
+		ids    []string
+		depths = make(map[string]int)
+		byID   = make(map[string]graphdriver.ImportedLayer)
+	)
+	for _, fi := range fis {
+		id := fi.Name()
+		if !stagedID.MatchString(id) {
+			continue
+		}
+		if !a.Exists(id) {
+			// Removed before it was registered
+			os.Remove(path.Join(dir, id))
+			continue
+		}
+		data, err := ioutil.ReadFile(path.Join(dir, id))
+		if err != nil {
+			return nil, err
+		}
+		parents, err := getParentIds(a.rootPath(), id)
+		if err != nil {
+			return nil, err
+		}
+		size, err := a.DiffSize(id, "")
+		if err != nil {
+			return nil, err
+		}
+		layer := graphdriver.ImportedLayer{ID: id, Size: size}
+		if len(parents) > 0 {
+			layer.Parent = parents[0]
+		}
+		if len(data) > 0 {
+			layer.Image = data
+		}
+		ids = append(ids, id)
+		depths[id] = len(parents)
+		byID[id] = layer
+	}
+	sort.Stable(sort.Reverse(byDepth{ids, depths}))
+	layers := make([]graphdriver.ImportedLayer, len(ids))
+	for i, id := range ids {
+		layers[i] = byID[id]
+	}
+	return layers, nil
+}
+
+// ReleaseImported drops the layer with the given id from ImportedLayers,
+// once it is registered.
+func (a *Driver) ReleaseImported(id string) error {
+	if err := os.Remove(path.Join(a.rootPath(), importedDir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// NotifyImported sets the function ImportStaged calls after it adopted
+// layers.
+func (a *Driver) NotifyImported(fn func()) {
+	a.Lock()
+	a.importHook = fn
+	a.Unlock()
+}
+
+// startStagingTrap runs ImportStaged whenever the daemon receives
+// SIGUSR2, until Cleanup.
+func (a *Driver) startStagingTrap() {
+	a.stagingSignals = make(chan os.Signal, 1)
+	signal.Notify(a.stagingSignals, syscall.SIGUSR2)
+	go func() {
+		for range a.stagingSignals {
+			if _, err := a.ImportStaged(); err != nil {
+				logrus.Errorf("aufs: importing staged layers: %v", err)
+			}
+		}
+	}()
+}
+
+func (a *Driver) stopStagingTrap() {
+	if a.stagingSignals != nil {
+		signal.Stop(a.stagingSignals)
+		close(a.stagingSignals)
+	}
+}
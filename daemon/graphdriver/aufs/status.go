@@ -0,0 +1,159 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+)
+
+// StatusInfo is the machine-readable form of Status.
+type StatusInfo struct {
+	RootDir           string       `json:"rootDir"`
+	BackingFilesystem string       `json:"backingFilesystem"`
+	Dirs              int          `json:"dirs"`
+	DirsCountedAt     time.Time    `json:"dirsCountedAt"`
+	DirpermSupported  bool         `json:"dirpermSupported"`
+	Profile           string       `json:"profile,omitempty"`
+	Durability        string       `json:"durability"`
+	Layout            string       `json:"layout"`
+	Mounts            int          `json:"mounts"`
+	MountedBranches   int          `json:"mountedBranches"`
+	ActiveLayers      int          `json:"activeLayers"`
+	ReadOnlyExports   int          `json:"readOnlyExports"`
+	MountIdleTimeout  string       `json:"mountIdleTimeout,omitempty"`
+	MountTimeout      string       `json:"mountTimeout,omitempty"`
+	VerifyRemove      bool         `json:"verifyRemove"`
+	Whiteouts         string       `json:"whiteouts"`
+	CreatePool        int          `json:"createPool"`
+	MaxBranches       int          `json:"maxBranches,omitempty"`
+	MaxMounts         int          `json:"maxMounts,omitempty"`
+	Hook              string       `json:"hook,omitempty"`
+	MetricsFile       string       `json:"metricsFile,omitempty"`
+	DirPool           *PoolStatus  `json:"dirPool,omitempty"`
+	Capabilities      Capabilities `json:"capabilities"`
+}
+
+// PoolStatus describes the pool of pre-created layer directories.
+type PoolStatus struct {
+	Size      int  `json:"size"`
+	Free      int  `json:"free"`
+	Refilling bool `json:"refilling"`
+}
+
+// StructuredStatus returns the status of the driver as a *StatusInfo.
+func (a *Driver) StructuredStatus() interface{} {
+	return a.statusInfo()
+}
+
+func (a *Driver) statusInfo() *StatusInfo {
+	dirs, countedAt := a.countedLayers()
+	u := a.currentUsage()
+	info := &StatusInfo{
+		RootDir:           a.rootPath(),
+		BackingFilesystem: backingFs,
+		Dirs:              dirs,
+		DirsCountedAt:     countedAt,
+		DirpermSupported:  useDirperm(),
+		Profile:           a.options.profile,
+		Durability:        a.options.durability.String(),
+		VerifyRemove:      a.options.verifyRemove,
+		Whiteouts:         whiteoutsName(a.options.whiteouts),
+		CreatePool:        a.options.createPool,
+		MaxBranches:       a.options.maxBranches,
+		MaxMounts:         a.options.maxMounts,
+		MetricsFile:       a.options.metricsFile,
+		Layout:            layoutName(a.options.sharded),
+		Mounts:            u.mounts,
+		MountedBranches:   u.branches,
+		ActiveLayers:      u.active,
+		ReadOnlyExports:   u.exports,
+		Capabilities:      a.Capabilities(),
+	}
+	if a.options.mountIdleTimeout > 0 {
+		info.MountIdleTimeout = a.options.mountIdleTimeout.String()
+	}
+	if a.options.mountTimeout > 0 {
+		info.MountTimeout = a.options.mountTimeout.String()
+	}
+	if a.options.hook != nil {
+		info.Hook = a.options.hook.path
+	}
+
+	if p := a.pool; p != nil {
+		p.Lock()
+		info.DirPool = &PoolStatus{Size: p.size, Free: len(p.free), Refilling: p.refilling}
+		p.Unlock()
+	}
+	return info
+}
+
+// Status returns the status of the driver as key-value pairs, followed
+// by the value every option resolved to, whether it was given explicitly,
+// came from the profile or is the default.
+func (a *Driver) Status() [][2]string {
+	info := a.statusInfo()
+	status := [][2]string{
+		{"Root Dir", info.RootDir},
+		{"Backing Filesystem", info.BackingFilesystem},
+		{"Dirs", fmt.Sprintf("%d", info.Dirs)},
+		{"Dirperm1 Supported", fmt.Sprintf("%v", info.DirpermSupported)},
+		{"Durability", info.Durability},
+		{"Layout", info.Layout},
+		{"Mounts", fmt.Sprintf("%d", info.Mounts)},
+		{"Mounted Branches", fmt.Sprintf("%d", info.MountedBranches)},
+	}
+	if info.Profile != "" {
+		status = append(status, [2]string{"Profile", info.Profile})
+	}
+	return append(status, a.optionStatus()...)
+}
+
+// optionStatus describes the resolved options in the terms of docker info.
+func (a *Driver) optionStatus() [][2]string {
+	o := a.options
+	maxBranches, maxMounts := "default", "unlimited"
+	if o.maxBranches > 0 {
+		maxBranches = fmt.Sprintf("%d", o.maxBranches)
+	}
+	if o.maxMounts > 0 {
+		maxMounts = fmt.Sprintf("%d", o.maxMounts)
+	}
+	mountIdleTimeout, mountTimeout := "disabled", "none"
+	if o.mountIdleTimeout > 0 {
+		mountIdleTimeout = o.mountIdleTimeout.String()
+	}
+	if o.mountTimeout > 0 {
+		mountTimeout = o.mountTimeout.String()
+	}
+	status := [][2]string{
+		{"Whiteouts", whiteoutsName(o.whiteouts)},
+		{"Create Pool", fmt.Sprintf("%d", o.createPool)},
+		{"Max Branches", maxBranches},
+		{"Max Mounts", maxMounts},
+		{"Mount Idle Timeout", mountIdleTimeout},
+		{"Mount Timeout", mountTimeout},
+		{"Verify Remove", fmt.Sprintf("%v", o.verifyRemove)},
+	}
+	if h := o.hook; h != nil {
+		status = append(status,
+			[2]string{"Hook", h.path},
+			[2]string{"Hook Timeout", h.timeout.String()},
+			[2]string{"Hook Fail Open", fmt.Sprintf("%v", h.failOpen)})
+	}
+	if o.metricsFile != "" {
+		status = append(status,
+			[2]string{"Metrics File", o.metricsFile},
+			[2]string{"Metrics Interval", o.metricsInterval.String()})
+	}
+	return status
+}
+
+func whiteoutsName(format archive.WhiteoutFormat) string {
+	if format == archive.OCIWhiteoutFormat {
+		return "oci"
+	}
+	return "aufs"
+}
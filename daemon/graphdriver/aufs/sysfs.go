@@ -0,0 +1,155 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+
+	mountpk "github.com/docker/docker/pkg/mount"
+)
+
+// The kernel describes each aufs mount, identified by the si= option it
+// shows in the mount table, under /sys/fs/aufs/si_<si>. The pseudo-link
+// counts are only found in debugfs.
+var (
+	aufsSysfs   = "/sys/fs/aufs"
+	aufsDebugfs = "/sys/kernel/debug/aufs"
+)
+
+// SysfsStats is the view of the kernel of an aufs mount.
+type SysfsStats struct {
+	SI string `json:"si"`
+	// Branches are the branches of the mount, top first, as
+	// "<path>=<permission>".
+	Branches []string `json:"branches"`
+	XinoPath string   `json:"xinoPath,omitempty"`
+	// Xino is the space used by the external inode number files, as
+	// reported by the kernel.
+	Xino string `json:"xino,omitempty"`
+	// Plinks is the number of pseudo-links, -1 if debugfs is unavailable.
+	Plinks int `json:"plinks"`
+}
+
+// MountStats returns the view of the kernel of the mount of the layer with
+// the given id, or nil if the layer is not mounted.
+func (a *Driver) MountStats(id string) (*SysfsStats, error) {
+	mounts, err := mountpk.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	target := a.layerPath("mnt", id)
+	for _, m := range mounts {
+		if m.Mountpoint == target && m.Fstype == "aufs" {
+			return mountStats(m)
+		}
+	}
+	return nil, nil
+}
+
+// allMountStats returns the view of the kernel of every mount of the
+// driver, by layer id.
+func (a *Driver) allMountStats() (map[string]*SysfsStats, error) {
+	mounts, err := mountpk.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	prefix := path.Join(a.rootPath(), "mnt") + "/"
+	all := make(map[string]*SysfsStats)
+	for _, m := range mounts {
+		if !strings.HasPrefix(m.Mountpoint, prefix) || m.Fstype != "aufs" {
+			continue
+		}
+		stats, err := mountStats(m)
+		if err != nil {
+			return nil, err
+		}
+		all[path.Base(m.Mountpoint)] = stats
+	}
+	return all, nil
+}
+
+func mountStats(m *mountpk.MountInfo) (*SysfsStats, error) {
+	si := mountSI(m.VfsOpts)
+	if si == "" {
+		return nil, fmt.Errorf("aufs: no si option on the mount at %s", m.Mountpoint)
+	}
+	return readSysfsStats(si)
+}
+
+// mountSI returns the value of the si option of an aufs mount.
+func mountSI(opts string) string {
+	for _, opt := range strings.Split(opts, ",") {
+		if strings.HasPrefix(opt, "si=") {
+			return strings.TrimPrefix(opt, "si=")
+		}
+	}
+	return ""
+}
+
+func readSysfsStats(si string) (*SysfsStats, error) {
+	dir := path.Join(aufsSysfs, "si_"+si)
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	stats := &SysfsStats{SI: si, Plinks: -1}
+
+	var indexes []int
+	for _, fi := range fis {
+		if !strings.HasPrefix(fi.Name(), "br") {
+			continue
+		}
+		// Leaves out brid<n>, the ids of the branches
+		if i, err := strconv.Atoi(strings.TrimPrefix(fi.Name(), "br")); err == nil {
+			indexes = append(indexes, i)
+		}
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		b, err := readSysfsFile(path.Join(dir, fmt.Sprintf("br%d", i)))
+		if err != nil {
+			return nil, err
+		}
+		stats.Branches = append(stats.Branches, b)
+	}
+
+	if stats.XinoPath, err = readSysfsFile(path.Join(dir, "xi_path")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if stats.Xino, err = readSysfsFile(path.Join(dir, "xino")); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if plinks, err := readSysfsFile(path.Join(aufsDebugfs, "si_"+si, "plinks")); err == nil {
+		stats.Plinks = parsePlinks(plinks)
+	}
+	return stats, nil
+}
+
+// parsePlinks returns the total of the plinks file of debugfs, the first
+// field of its last line, or -1 if it cannot be parsed.
+func parsePlinks(s string) int {
+	lines := strings.Split(s, "\n")
+	fields := strings.Fields(lines[len(lines)-1])
+	if len(fields) == 0 {
+		return -1
+	}
+	n, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return -1
+	}
+	return n
+}
+
+func readSysfsFile(p string) (string, error) {
+	b, err := ioutil.ReadFile(p)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
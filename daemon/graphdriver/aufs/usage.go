@@ -0,0 +1,33 @@
+// +build linux
+
+package aufs
+
+import (
+	"sync"
+)
+
+// use marks the layers with the given ids as in use by an operation that
+// reads or writes their directories without the driver lock, such as Diff
+// or ApplyDiff, so that MigrateLayout leaves them where they are. Paths
+// must be looked up after use returns. The returned function ends the use
+// and may be called more than once.
+func (a *Driver) use(ids ...string) func() {
+	a.useLock.Lock()
+	for _, id := range ids {
+		a.using[id]++
+	}
+	a.useLock.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			a.useLock.Lock()
+			for _, id := range ids {
+				if a.using[id]--; a.using[id] <= 0 {
+					delete(a.using, id)
+				}
+			}
+			a.useLock.Unlock()
+		})
+	}
+}
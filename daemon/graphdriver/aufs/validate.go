@@ -0,0 +1,85 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// ValidateChain checks, without mounting anything, that the layer with
+// the given id could be mounted: its chain resolves, every branch is a
+// readable directory, the chain fits in the branch limit and every branch
+// fits in the mount options. It returns all the problems found, nil when
+// there are none.
+func (a *Driver) ValidateChain(id string) []error {
+	if !a.Exists(id) {
+		return []error{fmt.Errorf("aufs: unknown layer %s", id)}
+	}
+	_, entries, err := readChain(a.rootPath(), id)
+	if err != nil {
+		return []error{err}
+	}
+	parents := make([]string, len(entries))
+	for i, e := range entries {
+		parents[i] = e.id
+	}
+
+	var problems []error
+	if dir := a.layerPath("mnt", id); !pathExists(dir) {
+		problems = append(problems, fmt.Errorf("aufs: mount point %s is missing", dir))
+	}
+	if branches, limit := len(parents)+1, a.branchLimit(); branches > limit {
+		problems = append(problems, fmt.Errorf("aufs: %s has %d branches, the limit is %d", id, branches, limit))
+	}
+
+	room := mountOptionRoom("")
+	for i, layer := range append([]string{id}, parents...) {
+		branch := a.layerPath("diff", layer)
+		if err := checkBranch(branch); err != nil {
+			problems = append(problems, err)
+		}
+		// The rw branch goes in the initial options, each ro branch is
+		// appended with a remount of its own
+		opt := fmt.Sprintf("append:%s=ro+wh", branch)
+		if i == 0 {
+			opt = fmt.Sprintf("br:%s=rw", branch)
+		}
+		if len(opt) > room {
+			problems = append(problems, fmt.Errorf("aufs: branch %s does not fit in the mount options", branch))
+		}
+	}
+
+	// A parent that was re-applied from another diff no longer matches
+	// what its children were built on
+	for _, e := range entries {
+		if e.digest == "" {
+			continue
+		}
+		if self, _, err := readChain(a.rootPath(), e.id); err == nil && self.digest != "" && self.digest != e.digest {
+			problems = append(problems, fmt.Errorf("aufs: parent %s of %s is %s, expected %s", e.id, id, self.digest, e.digest))
+		}
+	}
+	return problems
+}
+
+// checkBranch verifies that the directory p can be used as a branch.
+func checkBranch(p string) error {
+	f, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("aufs: branch %s is not a directory", p)
+	}
+	if _, err := f.Readdirnames(1); err != nil && err != io.EOF {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+// +build linux
+
+package aufs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/docker/docker/pkg/archive"
+)
+
+// VerifyReport is the outcome of a VerifyLayers sweep.
+type VerifyReport struct {
+	Checked int `json:"checked"`
+	// Failed maps the layers that failed ValidateChain, or whose content
+	// no longer matches what was recorded, to their problems.
+	Failed map[string][]string `json:"failed,omitempty"`
+	// Next is the id to resume the sweep after, empty once every layer
+	// was checked.
+	Next string `json:"next,omitempty"`
+}
+
+// VerifyLayers runs ValidateChain on at most limit layers, in id order,
+// starting after the given id, and waits pause between two layers to
+// bound the load on the store. The content of the layers that recorded
+// the digest of their content when they were applied is read back and
+// hashed again, to find the layers that were corrupted or changed since.
+// A limit of 0 checks all remaining layers. Sweeps over large stores can
+// be split by passing the Next of a report to the following call.
+func (a *Driver) VerifyLayers(after string, limit int, pause time.Duration) (*VerifyReport, error) {
+	ids, err := a.ListLayers("", after, limit)
+	if err != nil {
+		return nil, err
+	}
+	report := &VerifyReport{Failed: make(map[string][]string)}
+	for i, id := range ids {
+		if i > 0 && pause > 0 {
+			time.Sleep(pause)
+		}
+		for _, err := range a.ValidateChain(id) {
+			report.Failed[id] = append(report.Failed[id], err.Error())
+		}
+		if err := a.verifyContent(id); err != nil {
+			report.Failed[id] = append(report.Failed[id], err.Error())
+		}
+		report.Checked++
+	}
+	if limit > 0 && len(ids) == limit {
+		report.Next = ids[len(ids)-1]
+	}
+	return report, nil
+}
+
+// verifyContent checks the content of the layer with the given id against
+// the digest and size recorded when it was applied, if any.
+func (a *Driver) verifyContent(id string) error {
+	self, _, err := readChain(a.rootPath(), id)
+	if err != nil {
+		// Reported by ValidateChain
+		return nil
+	}
+	if self.contentDigest == "" {
+		return nil
+	}
+	digest, size, err := a.contentDigest(id)
+	if err != nil {
+		return err
+	}
+	if digest != self.contentDigest || size != self.contentSize {
+		return fmt.Errorf("aufs: content of %s is %s (%d bytes), %s (%d bytes) was recorded", id, digest, size, self.contentDigest, self.contentSize)
+	}
+	return nil
+}
+
+// contentDigest returns the digest and size of the archive Diff makes of
+// the layer with the given id, which only change with its content. The
+// format of the whiteouts is fixed, so changing aufs.whiteouts does not
+// change the digest.
+func (a *Driver) contentDigest(id string) (string, int64, error) {
+	arch, err := a.diff(id, archive.AUFSWhiteoutFormat)
+	if err != nil {
+		return "", 0, err
+	}
+	defer arch.Close()
+	h := sha256.New()
+	size, err := io.Copy(h, arch)
+	if err != nil {
+		return "", 0, err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), size, nil
+}
@@ -0,0 +1,59 @@
+// +build linux
+
+package aufs
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/pkg/stringid"
+)
+
+// ErrMountHung is returned when a mount or unmount of a layer did not
+// complete within aufs.mounttimeout. The layer is poisoned until the
+// operation eventually returns.
+type ErrMountHung struct {
+	ID string
+	Op string
+}
+
+func (e ErrMountHung) Error() string {
+	return fmt.Sprintf("aufs: %s of %s is hung, refusing to use the layer until it completes", e.Op, stringid.TruncateID(e.ID))
+}
+
+// watchdog runs fn, the mount or unmount op of the layer id, and gives up
+// waiting for it after the configured mount timeout so that a syscall
+// stuck on a vanished branch does not hold the driver lock forever. The
+// layer is then poisoned: further mounts and unmounts of it fail right
+// away until fn returns. Must be called with the driver lock held.
+func (a *Driver) watchdog(id, op string, fn func() error) error {
+	if a.poisoned[id] {
+		return ErrMountHung{ID: id, Op: op}
+	}
+	timeout := a.options.mountTimeout
+	if timeout == 0 {
+		return fn()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- fn()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+	}
+
+	logrus.Errorf("aufs: %s of %s did not complete within %s, poisoning the layer", op, stringid.TruncateID(id), timeout)
+	a.poisoned[id] = true
+	go func() {
+		err := <-done
+		logrus.Warnf("aufs: hung %s of %s completed: %v", op, stringid.TruncateID(id), err)
+		a.Lock()
+		delete(a.poisoned, id)
+		a.Unlock()
+	}()
+	return ErrMountHung{ID: id, Op: op}
+}
@@ -3,6 +3,7 @@ package graphdriver
 import (
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -84,6 +85,118 @@ type Driver interface {
 	DiffSize(id, parent string) (size int64, err error)
 }
 
+// StructuredStatusDriver is implemented by drivers that can also report
+// their status as a structure with machine-readable fields, in addition
+// to the key-value pairs returned by Status.
+type StructuredStatusDriver interface {
+	// StructuredStatus returns a value that marshals to a JSON object.
+	StructuredStatus() interface{}
+}
+
+// RemoveChecker is implemented by drivers that can refuse to remove a
+// layer, e.g. because other layers are still built on it.
+type RemoveChecker interface {
+	// CheckRemove returns the error Remove would fail with for the layer
+	// with the given id, without removing anything.
+	CheckRemove(id string) error
+}
+
+// Pinner is implemented by drivers that can protect a layer from removal
+// until it is unpinned.
+type Pinner interface {
+	Pin(id string) error
+	Unpin(id string) error
+}
+
+// LayerInfo describes a layer of the store of a driver.
+type LayerInfo struct {
+	ID string
+	// Size is the size of the diff the layer was applied from, -1 if
+	// unknown.
+	Size int64
+	// Parents is the number of layers the layer is built on.
+	Parents int
+	// Children is the number of layers directly built on the layer.
+	Children int
+	// Active is the number of references held on the layer.
+	Active int
+}
+
+// LayerLister is implemented by drivers that can describe every layer
+// of their store.
+type LayerLister interface {
+	LayerInfos() ([]LayerInfo, error)
+}
+
+// Placement describes where the content of a layer is stored.
+type Placement struct {
+	Path string
+	// Device is the device holding the content, as major:minor.
+	Device     string
+	Mountpoint string
+	Fstype     string
+	Source     string
+}
+
+// Placer is implemented by drivers that can tell where the content of a
+// layer is stored, so that volumes can be put on the same device or
+// deliberately on another one.
+type Placer interface {
+	Placement(id string) (*Placement, error)
+}
+
+// ReadOnlyBinder is implemented by drivers that can bind-mount the content
+// of a single layer read-only outside of their root, for scanners and
+// backup agents.
+type ReadOnlyBinder interface {
+	BindReadOnly(id, target string) error
+	UnbindReadOnly(target string) error
+}
+
+// ImportedLayer is a layer a driver adopted out of band.
+type ImportedLayer struct {
+	ID     string
+	Parent string
+	// Image is the image json staged along with the layer, nil if none.
+	Image []byte
+	// Size is the size of the content of the layer.
+	Size int64
+}
+
+// LayerImporter is implemented by drivers that adopt layers staged out of
+// band, so that the daemon can register them as images.
+type LayerImporter interface {
+	// ImportedLayers returns the adopted layers that were not released
+	// yet, parents first.
+	ImportedLayers() ([]ImportedLayer, error)
+	// ReleaseImported forgets the adopted layer with the given id once it
+	// is registered.
+	ReleaseImported(id string) error
+	// NotifyImported sets the function called after layers were adopted.
+	NotifyImported(fn func())
+}
+
+// Dumper is implemented by drivers that can describe their state for bug
+// reports.
+type Dumper interface {
+	// Dump writes a tar archive describing the state of the driver to w.
+	Dump(w io.Writer) error
+}
+
+// SelfTestResult is the outcome of one of the checks of a SelfTester.
+type SelfTestResult struct {
+	Name    string
+	Passed  bool
+	Skipped bool
+	Detail  string
+}
+
+// SelfTester is implemented by drivers that can check that the host they
+// run on provides what they rely on.
+type SelfTester interface {
+	SelfTest() []SelfTestResult
+}
+
 func init() {
 	drivers = make(map[string]InitFunc)
 }
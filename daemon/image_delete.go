@@ -27,10 +27,7 @@ func (daemon *Daemon) ImageDelete(name string, force, noprune bool) ([]types.Ima
 }
 
 func (daemon *Daemon) imgDeleteHelper(name string, list *[]types.ImageDelete, first, force, noprune bool) error {
-	var (
-		repoName, tag string
-		tags          = []string{}
-	)
+	var repoName, tag string
 	repoAndTags := make(map[string][]string)
 
 	// FIXME: please respect DRY and centralize repo+tag parsing in a single central place! -- shykes
@@ -99,6 +96,20 @@ func (daemon *Daemon) imgDeleteHelper(name string, list *[]types.ImageDelete, fi
 		}
 	}
 
+	// Decide whether the image goes away before untagging it, so the
+	// driver can refuse before the image is left untagged
+	var untagging int
+	for _, tags := range repoAndTags {
+		untagging += len(tags)
+	}
+	left := len(repos) - untagging
+	deleting := len(byParents[img.ID]) == 0 && (left <= 0 || (left <= 1 && repoName == ""))
+	if deleting {
+		if err := daemon.Graph().CheckDelete(img.ID); err != nil {
+			return err
+		}
+	}
+
 	// Untag the current image
 	for repoName, tags := range repoAndTags {
 		for _, tag := range tags {
@@ -114,26 +125,22 @@ func (daemon *Daemon) imgDeleteHelper(name string, list *[]types.ImageDelete, fi
 			}
 		}
 	}
-	tags = daemon.Repositories().ByID()[img.ID]
-	if (len(tags) <= 1 && repoName == "") || len(tags) == 0 {
-		if len(byParents[img.ID]) == 0 {
-			if err := daemon.Repositories().DeleteAll(img.ID); err != nil {
-				return err
-			}
-			if err := daemon.Graph().Delete(img.ID); err != nil {
+	if deleting {
+		if err := daemon.Repositories().DeleteAll(img.ID); err != nil {
+			return err
+		}
+		if err := daemon.Graph().Delete(img.ID); err != nil {
+			return err
+		}
+		*list = append(*list, types.ImageDelete{
+			Deleted: img.ID,
+		})
+		daemon.EventsService.Log("delete", img.ID, "")
+		if img.Parent != "" && !noprune {
+			err := daemon.imgDeleteHelper(img.Parent, list, false, force, noprune)
+			if first {
 				return err
 			}
-			*list = append(*list, types.ImageDelete{
-				Deleted: img.ID,
-			})
-			daemon.EventsService.Log("delete", img.ID, "")
-			if img.Parent != "" && !noprune {
-				err := daemon.imgDeleteHelper(img.Parent, list, false, force, noprune)
-				if first {
-					return err
-				}
-
-			}
 
 		}
 	}
@@ -0,0 +1,34 @@
+package daemon
+
+import (
+	"fmt"
+
+	"github.com/docker/docker/daemon/graphdriver"
+)
+
+// ImagePin protects the layer of the named image from removal, or lifts
+// the protection, with storage drivers that support it.
+func (daemon *Daemon) ImagePin(name string, pin bool) error {
+	img, err := daemon.Repositories().LookupImage(name)
+	if err != nil {
+		return err
+	}
+	p, ok := daemon.driver.(graphdriver.Pinner)
+	if !ok {
+		return fmt.Errorf("Storage driver %s does not support pinning images", daemon.driver)
+	}
+	if pin {
+		err = p.Pin(img.ID)
+	} else {
+		err = p.Unpin(img.ID)
+	}
+	if err != nil {
+		return err
+	}
+	if pin {
+		daemon.EventsService.Log("pin", img.ID, "")
+	} else {
+		daemon.EventsService.Log("unpin", img.ID, "")
+	}
+	return nil
+}
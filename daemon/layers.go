@@ -0,0 +1,155 @@
+package daemon
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/autogen/dockerversion"
+	"github.com/docker/docker/daemon/graphdriver"
+	"github.com/docker/docker/image"
+)
+
+// Layers describes every layer of the storage driver, with the drivers
+// that support it.
+func (daemon *Daemon) Layers() ([]types.Layer, error) {
+	lister, ok := daemon.driver.(graphdriver.LayerLister)
+	if !ok {
+		return nil, fmt.Errorf("Storage driver %s does not support listing layers", daemon.driver)
+	}
+	infos, err := lister.LayerInfos()
+	if err != nil {
+		return nil, err
+	}
+	layers := make([]types.Layer, len(infos))
+	for i, info := range infos {
+		layers[i] = types.Layer{
+			ID:       info.ID,
+			Location: daemon.layerLocation(info.ID),
+			Size:     info.Size,
+			Parents:  info.Parents,
+			Active:   info.Active,
+			SharedBy: info.Children,
+		}
+	}
+	return layers, nil
+}
+
+// LayerPlacement tells where the content of the layer with the given id
+// is stored, with storage drivers that support it.
+func (daemon *Daemon) LayerPlacement(id string) (*types.LayerPlacement, error) {
+	p, ok := daemon.driver.(graphdriver.Placer)
+	if !ok {
+		return nil, fmt.Errorf("Storage driver %s does not support placement", daemon.driver)
+	}
+	if !daemon.driver.Exists(id) {
+		return nil, fmt.Errorf("No such layer: %s", id)
+	}
+	placement, err := p.Placement(id)
+	if err != nil {
+		return nil, err
+	}
+	return &types.LayerPlacement{
+		Path:       placement.Path,
+		Device:     placement.Device,
+		Mountpoint: placement.Mountpoint,
+		Fstype:     placement.Fstype,
+		Source:     placement.Source,
+	}, nil
+}
+
+// LayerBindReadOnly bind-mounts the content of the layer with the given id
+// read-only at target, with storage drivers that support it.
+func (daemon *Daemon) LayerBindReadOnly(id, target string) error {
+	b, err := daemon.readOnlyBinder(target)
+	if err != nil {
+		return err
+	}
+	if !daemon.driver.Exists(id) {
+		return fmt.Errorf("No such layer: %s", id)
+	}
+	return b.BindReadOnly(id, target)
+}
+
+// LayerUnbindReadOnly releases a mount made with LayerBindReadOnly.
+func (daemon *Daemon) LayerUnbindReadOnly(target string) error {
+	b, err := daemon.readOnlyBinder(target)
+	if err != nil {
+		return err
+	}
+	return b.UnbindReadOnly(target)
+}
+
+func (daemon *Daemon) readOnlyBinder(target string) (graphdriver.ReadOnlyBinder, error) {
+	b, ok := daemon.driver.(graphdriver.ReadOnlyBinder)
+	if !ok {
+		return nil, fmt.Errorf("Storage driver %s does not support read-only binds", daemon.driver)
+	}
+	if !filepath.IsAbs(target) {
+		return nil, fmt.Errorf("Bad parameter: target %q is not an absolute path", target)
+	}
+	return b, nil
+}
+
+// registerImported registers the layers the storage driver adopted out of
+// band as images, with the image json staged along with them if any, so
+// that they can be used like pulled ones. Layers whose parent is not an
+// image are left for a later call.
+func (daemon *Daemon) registerImported() {
+	daemon.importLock.Lock()
+	defer daemon.importLock.Unlock()
+
+	importer := daemon.driver.(graphdriver.LayerImporter)
+	layers, err := importer.ImportedLayers()
+	if err != nil {
+		logrus.Errorf("Error listing the layers imported by %s: %v", daemon.driver, err)
+		return
+	}
+	for _, l := range layers {
+		if !daemon.graph.Exists(l.ID) {
+			img := &image.Image{
+				ID:            l.ID,
+				Parent:        l.Parent,
+				Created:       time.Now().UTC(),
+				DockerVersion: dockerversion.VERSION,
+				Architecture:  runtime.GOARCH,
+				OS:            runtime.GOOS,
+			}
+			if l.Image != nil {
+				if img, err = image.NewImgJSON(l.Image); err != nil {
+					logrus.Errorf("Error registering imported layer %s: invalid image json: %v", l.ID, err)
+					continue
+				}
+			}
+			if img.ID != l.ID || img.Parent != l.Parent {
+				logrus.Errorf("Error registering imported layer %s: its image json is for %s on %s", l.ID, img.ID, img.Parent)
+				continue
+			}
+			img.Size = l.Size
+			if err := daemon.graph.RegisterExisting(img); err != nil {
+				logrus.Errorf("Error registering imported layer %s: %v", l.ID, err)
+				continue
+			}
+			daemon.EventsService.Log("import", l.ID, "")
+		}
+		if err := importer.ReleaseImported(l.ID); err != nil {
+			logrus.Errorf("Error releasing imported layer %s: %v", l.ID, err)
+		}
+	}
+}
+
+// layerLocation tells what the layer with the given id belongs to.
+func (daemon *Daemon) layerLocation(id string) string {
+	// The rw layer of a container sits on its -init layer
+	if daemon.containers.Get(strings.TrimSuffix(id, "-init")) != nil {
+		return "local-container"
+	}
+	if daemon.Graph().Exists(id) {
+		return "local-image"
+	}
+	return "local"
+}
@@ -0,0 +1,39 @@
+package daemon
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/daemon/graphdriver"
+)
+
+// StorageDump writes a tar archive describing the state of the storage
+// driver to w, with storage drivers that support it.
+func (daemon *Daemon) StorageDump(w io.Writer) error {
+	d, ok := daemon.driver.(graphdriver.Dumper)
+	if !ok {
+		return fmt.Errorf("Storage driver %s does not support dumping its state", daemon.driver)
+	}
+	return d.Dump(w)
+}
+
+// StorageSelfTest checks that the host provides what the storage driver
+// relies on, with storage drivers that support it.
+func (daemon *Daemon) StorageSelfTest() ([]types.SelfTestResult, error) {
+	t, ok := daemon.driver.(graphdriver.SelfTester)
+	if !ok {
+		return nil, fmt.Errorf("Storage driver %s does not support self tests", daemon.driver)
+	}
+	checks := t.SelfTest()
+	results := make([]types.SelfTestResult, len(checks))
+	for i, c := range checks {
+		results[i] = types.SelfTestResult{
+			Name:    c.Name,
+			Passed:  c.Passed,
+			Skipped: c.Skipped,
+			Detail:  c.Detail,
+		}
+	}
+	return results, nil
+}
@@ -44,6 +44,7 @@ var (
 		{"info", "Display system-wide information"},
 		{"inspect", "Return low-level information on a container or image"},
 		{"kill", "Kill a running container"},
+		{"layer", "Manage the layers of the storage driver"},
 		{"load", "Load an image from a tar archive or STDIN"},
 		{"login", "Register or log in to a Docker registry"},
 		{"logout", "Log out from a Docker registry"},
@@ -63,6 +64,7 @@ var (
 		{"start", "Start one or more stopped containers"},
 		{"stats", "Display a live stream of container(s) resource usage statistics"},
 		{"stop", "Stop a running container"},
+		{"storage", "Inspect the storage driver"},
 		{"tag", "Tag an image into a repository"},
 		{"top", "Display the running processes of a container"},
 		{"unpause", "Unpause all processes within a container"},
@@ -212,6 +212,44 @@ func (graph *Graph) Register(img *image.Image, layerData archive.ArchiveReader)
 	return nil
 }
 
+// RegisterExisting registers an image for a layer the driver already
+// holds, e.g. one adopted out of band, without touching the layer.
+func (graph *Graph) RegisterExisting(img *image.Image) (err error) {
+	if err := image.ValidateID(img.ID); err != nil {
+		return err
+	}
+
+	graph.imageMutex.Lock(img.ID)
+	defer graph.imageMutex.Unlock(img.ID)
+
+	if graph.Exists(img.ID) {
+		return fmt.Errorf("Image %s already exists", img.ID)
+	}
+	if !graph.driver.Exists(img.ID) {
+		return fmt.Errorf("Driver %s has no layer %s", graph.driver, img.ID)
+	}
+	if img.Parent != "" && !graph.Exists(img.Parent) {
+		return fmt.Errorf("Parent image %s of %s is not registered", img.Parent, img.ID)
+	}
+	if err := os.RemoveAll(graph.imageRoot(img.ID)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	tmp, err := graph.mktemp("")
+	defer os.RemoveAll(tmp)
+	if err != nil {
+		return fmt.Errorf("mktemp failed: %s", err)
+	}
+	if err := graph.storeImage(img, nil, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, graph.imageRoot(img.ID)); err != nil {
+		return err
+	}
+	graph.idIndex.Add(img.ID)
+	return nil
+}
+
 // TempLayerArchive creates a temporary archive of the given image's filesystem layer.
 //   The archive is stored on disk and will be automatically deleted as soon as has been read.
 //   If output is not nil, a human-readable progress bar will be written to it.
@@ -278,12 +316,26 @@ func bufferToFile(f *os.File, src io.Reader) (int64, digest.Digest, error) {
 	return n, digest.NewDigest("sha256", h), nil
 }
 
+// CheckDelete returns the error the driver would refuse to remove the
+// layer of the image with, if it checks removals.
+func (graph *Graph) CheckDelete(id string) error {
+	if c, ok := graph.driver.(graphdriver.RemoveChecker); ok {
+		return c.CheckRemove(id)
+	}
+	return nil
+}
+
 // Delete atomically removes an image from the graph.
 func (graph *Graph) Delete(name string) error {
 	id, err := graph.idIndex.Get(name)
 	if err != nil {
 		return err
 	}
+	// Once the image is gone from the graph, nothing could remove a layer
+	// the driver refused to remove
+	if err := graph.CheckDelete(id); err != nil {
+		return err
+	}
 	tmp, err := graph.mktemp("")
 	graph.idIndex.Delete(id)
 	if err == nil {
@@ -297,7 +349,9 @@ func (graph *Graph) Delete(name string) error {
 		tmp = graph.imageRoot(id)
 	}
 	// Remove rootfs data from the driver
-	graph.driver.Remove(id)
+	if err := graph.driver.Remove(id); err != nil {
+		logrus.Errorf("Removing image %s from the driver: %v", stringid.TruncateID(id), err)
+	}
 	// Remove the trashed image directory
 	return os.RemoveAll(tmp)
 }
@@ -154,6 +154,46 @@ func TestRegister(t *testing.T) {
 	}
 }
 
+func TestRegisterExisting(t *testing.T) {
+	graph, driver := tempGraph(t)
+	defer nukeGraph(graph)
+
+	img := &image.Image{
+		ID:      stringid.GenerateRandomID(),
+		Created: time.Now(),
+		Size:    42,
+	}
+	if err := graph.RegisterExisting(img); err == nil {
+		t.Fatal("Expected an error for a layer the driver doesn't have")
+	}
+	if err := driver.Create(img.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.RegisterExisting(img); err != nil {
+		t.Fatal(err)
+	}
+	if resultImg, err := graph.Get(img.ID); err != nil {
+		t.Fatal(err)
+	} else if resultImg.Size != img.Size {
+		t.Fatalf("Wrong image size. Should be %d, not %d", img.Size, resultImg.Size)
+	}
+	if err := graph.RegisterExisting(img); err == nil {
+		t.Fatal("Expected an error for an image that already exists")
+	}
+
+	child := &image.Image{
+		ID:      stringid.GenerateRandomID(),
+		Parent:  stringid.GenerateRandomID(),
+		Created: time.Now(),
+	}
+	if err := driver.Create(child.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := graph.RegisterExisting(child); err == nil {
+		t.Fatal("Expected an error for an unregistered parent")
+	}
+}
+
 // Test that an image can be deleted by its shorthand prefix
 func TestDeletePrefix(t *testing.T) {
 	graph, _ := tempGraph(t)
@@ -132,3 +132,44 @@ func (s *DockerSuite) TestApiImagesHistory(c *check.C) {
 	c.Assert(len(historydata), check.Not(check.Equals), 0)
 	c.Assert(historydata[0].Tags[0], check.Equals, "test-api-images-history:latest")
 }
+
+func (s *DockerSuite) TestApiImagesPin(c *check.C) {
+	testRequires(c, AufsDriver)
+	name := "pinned"
+	_, err := buildImage(name, "FROM busybox\nRUN touch /pinned", true)
+	c.Assert(err, check.IsNil)
+
+	status, body, err := sockRequest("POST", "/images/"+name+"/pin", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusNoContent, check.Commentf(string(body)))
+
+	out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "rmi", name))
+	if err == nil || !strings.Contains(out, "layer is pinned") {
+		c.Fatalf("Expected docker rmi of a pinned image to be refused, got %s: %v", out, err)
+	}
+	images, _ := dockerCmd(c, "images")
+	c.Assert(strings.Contains(images, name), check.Equals, true, check.Commentf(images))
+
+	status, body, err = sockRequest("POST", "/images/"+name+"/unpin", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusNoContent, check.Commentf(string(body)))
+	dockerCmd(c, "rmi", name)
+}
+
+func (s *DockerSuite) TestApiImagesPinUnknownImage(c *check.C) {
+	for _, endpoint := range []string{"/images/foobar/pin", "/images/foobar/unpin"} {
+		status, _, err := sockRequest("POST", endpoint, nil)
+		c.Assert(err, check.IsNil)
+		c.Assert(status, check.Equals, http.StatusNotFound)
+	}
+}
+
+func (s *DockerSuite) TestApiImagesPinUnsupported(c *check.C) {
+	testRequires(c, NotAufsDriver)
+	for _, endpoint := range []string{"/images/busybox/pin", "/images/busybox/unpin"} {
+		status, body, err := sockRequest("POST", endpoint, nil)
+		c.Assert(err, check.IsNil)
+		c.Assert(status, check.Equals, http.StatusInternalServerError)
+		c.Assert(strings.Contains(string(body), "does not support pinning images"), check.Equals, true)
+	}
+}
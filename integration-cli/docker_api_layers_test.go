@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-check/check"
+)
+
+func (s *DockerSuite) TestApiLayersPlacement(c *check.C) {
+	testRequires(c, AufsDriver)
+	id, err := inspectField("busybox", "Id")
+	c.Assert(err, check.IsNil)
+
+	status, body, err := sockRequest("GET", "/layers/"+id+"/placement", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusOK)
+
+	var placement types.LayerPlacement
+	c.Assert(json.Unmarshal(body, &placement), check.IsNil)
+	if !strings.HasSuffix(placement.Path, "/diff/"+id) || placement.Device == "" || placement.Mountpoint == "" {
+		c.Fatalf("Expected the placement of the diff of %s, got %+v", id, placement)
+	}
+}
+
+func (s *DockerSuite) TestApiLayersPlacementUnknownLayer(c *check.C) {
+	testRequires(c, AufsDriver)
+	status, body, err := sockRequest("GET", "/layers/foobar/placement", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusNotFound)
+	c.Assert(strings.Contains(string(body), "No such layer"), check.Equals, true)
+}
+
+func (s *DockerSuite) TestApiLayersPlacementUnsupported(c *check.C) {
+	testRequires(c, NotAufsDriver)
+	id, err := inspectField("busybox", "Id")
+	c.Assert(err, check.IsNil)
+
+	status, body, err := sockRequest("GET", "/layers/"+id+"/placement", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusInternalServerError)
+	c.Assert(strings.Contains(string(body), "does not support placement"), check.Equals, true)
+}
+
+func (s *DockerSuite) TestApiLayersBind(c *check.C) {
+	testRequires(c, AufsDriver, SameHostDaemon)
+	id, err := inspectField("busybox", "Id")
+	c.Assert(err, check.IsNil)
+	target := filepath.Join(c.MkDir(), "layer")
+	query := "?target=" + url.QueryEscape(target)
+
+	status, body, err := sockRequest("POST", "/layers/"+id+"/bind"+query, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusNoContent, check.Commentf(string(body)))
+	mounts, err := ioutil.ReadFile("/proc/self/mountinfo")
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(string(mounts), " "+target+" "), check.Equals, true)
+
+	// A second bind at the same target is refused
+	status, _, err = sockRequest("POST", "/layers/"+id+"/bind"+query, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusInternalServerError)
+
+	status, body, err = sockRequest("POST", "/layers/unbind"+query, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusNoContent, check.Commentf(string(body)))
+	mounts, err = ioutil.ReadFile("/proc/self/mountinfo")
+	c.Assert(err, check.IsNil)
+	c.Assert(strings.Contains(string(mounts), " "+target+" "), check.Equals, false)
+
+	status, body, err = sockRequest("POST", "/layers/unbind"+query, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusInternalServerError)
+	c.Assert(strings.Contains(string(body), "nothing exported"), check.Equals, true)
+}
+
+func (s *DockerSuite) TestApiLayersBindErrors(c *check.C) {
+	testRequires(c, AufsDriver)
+	id, err := inspectField("busybox", "Id")
+	c.Assert(err, check.IsNil)
+
+	status, _, err := sockRequest("POST", "/layers/"+id+"/bind?target=relative", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusBadRequest)
+
+	status, _, err = sockRequest("POST", "/layers/foobar/bind?target=/foobar", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusNotFound)
+
+	status, _, err = sockRequest("POST", "/layers/unbind", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusBadRequest)
+}
+
+func (s *DockerSuite) TestApiLayersBindUnsupported(c *check.C) {
+	testRequires(c, NotAufsDriver)
+	id, err := inspectField("busybox", "Id")
+	c.Assert(err, check.IsNil)
+
+	for _, endpoint := range []string{"/layers/" + id + "/bind?target=/foobar", "/layers/unbind?target=/foobar"} {
+		status, body, err := sockRequest("POST", endpoint, nil)
+		c.Assert(err, check.IsNil)
+		c.Assert(status, check.Equals, http.StatusInternalServerError)
+		c.Assert(strings.Contains(string(body), "does not support read-only binds"), check.Equals, true)
+	}
+}
+
+func (s *DockerSuite) TestApiLayersList(c *check.C) {
+	testRequires(c, AufsDriver)
+	id, err := inspectField("busybox", "Id")
+	c.Assert(err, check.IsNil)
+	out, _ := dockerCmd(c, "create", "busybox", "true")
+	container := strings.TrimSpace(out)
+
+	status, body, err := sockRequest("GET", "/layers", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusOK)
+
+	var layers []types.Layer
+	c.Assert(json.Unmarshal(body, &layers), check.IsNil)
+	locations := make(map[string]string)
+	for _, l := range layers {
+		locations[l.ID] = l.Location
+	}
+	for layer, expected := range map[string]string{
+		id:                  "local-image",
+		container:           "local-container",
+		container + "-init": "local-container",
+	} {
+		if locations[layer] != expected {
+			c.Fatalf("Expected %s to be listed as %s, got %q", layer, expected, locations[layer])
+		}
+	}
+}
+
+func (s *DockerSuite) TestApiLayersListUnsupported(c *check.C) {
+	testRequires(c, NotAufsDriver)
+	status, body, err := sockRequest("GET", "/layers", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusInternalServerError)
+	c.Assert(strings.Contains(string(body), "does not support listing layers"), check.Equals, true)
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/go-check/check"
+)
+
+func (s *DockerSuite) TestApiStorageDump(c *check.C) {
+	testRequires(c, AufsDriver)
+	res, body, err := sockRequestRaw("GET", "/storage/dump", nil, "")
+	c.Assert(err, check.IsNil)
+	defer body.Close()
+	c.Assert(res.StatusCode, check.Equals, http.StatusOK)
+	c.Assert(res.Header.Get("Content-Type"), check.Equals, "application/x-tar")
+
+	names := map[string]bool{}
+	tr := tar.NewReader(body)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		c.Assert(err, check.IsNil)
+		names[hdr.Name] = true
+	}
+	for _, name := range []string{"status", "active.json", "mounts"} {
+		if !names[name] {
+			c.Fatalf("Expected %s in the dump, got %v", name, names)
+		}
+	}
+}
+
+func (s *DockerSuite) TestApiStorageSelfTest(c *check.C) {
+	testRequires(c, AufsDriver)
+	status, body, err := sockRequest("POST", "/storage/selftest", nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusOK)
+
+	var results []types.SelfTestResult
+	c.Assert(json.Unmarshal(body, &results), check.IsNil)
+	if len(results) == 0 || results[0].Name != "aufs supported" || !results[0].Passed {
+		c.Fatalf("Expected the aufs check to pass first, got %+v", results)
+	}
+}
+
+func (s *DockerSuite) TestApiStorageUnsupported(c *check.C) {
+	testRequires(c, NotAufsDriver)
+	for _, r := range []struct{ method, endpoint, msg string }{
+		{"GET", "/storage/dump", "does not support dumping its state"},
+		{"POST", "/storage/selftest", "does not support self tests"},
+	} {
+		status, body, err := sockRequest(r.method, r.endpoint, nil)
+		c.Assert(err, check.IsNil)
+		c.Assert(status, check.Equals, http.StatusInternalServerError)
+		if !strings.Contains(string(body), r.msg) {
+			c.Fatalf("Expected %q from %s, got %s", r.msg, r.endpoint, body)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+
+	"github.com/docker/docker/pkg/stringid"
+	"github.com/go-check/check"
+)
+
+func (s *DockerSuite) TestLayerLs(c *check.C) {
+	testRequires(c, AufsDriver)
+	id, err := inspectField("busybox", "Id")
+	c.Assert(err, check.IsNil)
+
+	out, _ := dockerCmd(c, "layer", "ls", "--no-trunc")
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	c.Assert(strings.HasPrefix(lines[0], "LAYER ID"), check.Equals, true, check.Commentf(out))
+	for _, line := range lines[1:] {
+		if fields := strings.Fields(line); fields[0] == id {
+			c.Assert(fields[1], check.Equals, "local-image")
+			return
+		}
+	}
+	c.Fatalf("Expected %s to be listed, got %s", id, out)
+}
+
+func (s *DockerSuite) TestLayerLsQuiet(c *check.C) {
+	testRequires(c, AufsDriver)
+	id, err := inspectField("busybox", "Id")
+	c.Assert(err, check.IsNil)
+
+	out, _ := dockerCmd(c, "layer", "ls", "-q")
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if line == stringid.TruncateID(id) {
+			return
+		}
+	}
+	c.Fatalf("Expected %s to be listed, got %s", stringid.TruncateID(id), out)
+}
+
+func (s *DockerSuite) TestLayerLsUnsupported(c *check.C) {
+	testRequires(c, NotAufsDriver)
+	out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "layer", "ls"))
+	if err == nil || !strings.Contains(out, "does not support listing layers") {
+		c.Fatalf("Expected docker layer ls to fail, got %s: %v", out, err)
+	}
+}
+
+func (s *DockerSuite) TestLayerUnknownCommand(c *check.C) {
+	out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "layer", "foo"))
+	if err == nil || !strings.Contains(out, "is not a docker layer command") {
+		c.Fatalf("Expected an unknown command error, got %s: %v", out, err)
+	}
+}
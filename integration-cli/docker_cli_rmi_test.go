@@ -2,7 +2,10 @@ package main
 
 import (
 	"fmt"
+	"net/http"
+	"net/url"
 	"os/exec"
+	"path/filepath"
 	"strings"
 
 	"github.com/go-check/check"
@@ -268,3 +271,51 @@ func (s *DockerSuite) TestRmiBlank(c *check.C) {
 		c.Fatalf("Expected error message not generated: %s", out)
 	}
 }
+
+func (s *DockerSuite) TestRmiRefusedWhileExported(c *check.C) {
+	testRequires(c, AufsDriver, SameHostDaemon)
+	name := "rmiexported"
+	id, err := buildImage(name, "FROM busybox\nRUN touch /exported", true)
+	c.Assert(err, check.IsNil)
+	target := filepath.Join(c.MkDir(), "layer")
+	query := "?target=" + url.QueryEscape(target)
+
+	status, body, err := sockRequest("POST", "/layers/"+id+"/bind"+query, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusNoContent, check.Commentf(string(body)))
+
+	out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "rmi", name))
+	if err == nil || !strings.Contains(out, target) {
+		c.Fatalf("Expected docker rmi to be refused because of the export at %s, got %s: %v", target, out, err)
+	}
+	// The image is refused before it is untagged
+	images, _ := dockerCmd(c, "images")
+	c.Assert(strings.Contains(images, name), check.Equals, true, check.Commentf(images))
+
+	status, body, err = sockRequest("POST", "/layers/unbind"+query, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(status, check.Equals, http.StatusNoContent, check.Commentf(string(body)))
+	dockerCmd(c, "rmi", name)
+}
+
+func (s *DockerDaemonSuite) TestRmiRefusedWithDependents(c *check.C) {
+	testRequires(c, AufsDriver)
+	c.Assert(s.d.StartWithBusybox("--storage-opt", "aufs.verifyremove=true"), check.IsNil)
+	name := "rmidependents"
+	out, err := s.d.Cmd("run", "--name", "parent", "busybox", "touch", "/parent")
+	c.Assert(err, check.IsNil, check.Commentf(out))
+	out, err = s.d.Cmd("commit", "parent", name)
+	c.Assert(err, check.IsNil, check.Commentf(out))
+	out, err = s.d.Cmd("create", name, "true")
+	c.Assert(err, check.IsNil, check.Commentf(out))
+	child := strings.TrimSpace(out)
+
+	// The stopped container lets -f through, its layers don't
+	out, err = s.d.Cmd("rmi", "-f", name)
+	if err == nil || !strings.Contains(out, child+"-init") {
+		c.Fatalf("Expected docker rmi to be refused because of the layers of %s, got %s: %v", child, out, err)
+	}
+	out, err = s.d.Cmd("images")
+	c.Assert(err, check.IsNil, check.Commentf(out))
+	c.Assert(strings.Contains(out, name), check.Equals, true, check.Commentf(out))
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-check/check"
+)
+
+func (s *DockerSuite) TestStorageDumpToFile(c *check.C) {
+	testRequires(c, AufsDriver, SameHostDaemon)
+	dir := c.MkDir()
+	file := filepath.Join(dir, "storage.tar")
+	dockerCmd(c, "storage", "dump", "-o", file)
+
+	f, err := os.Open(file)
+	c.Assert(err, check.IsNil)
+	defer f.Close()
+	hdr, err := tar.NewReader(f).Next()
+	c.Assert(err, check.IsNil)
+	c.Assert(hdr.Name, check.Equals, "status")
+}
+
+func (s *DockerSuite) TestStorageDumpToStdout(c *check.C) {
+	testRequires(c, AufsDriver)
+	cmd := exec.Command(dockerBinary, "storage", "dump")
+	stdout, err := cmd.StdoutPipe()
+	c.Assert(err, check.IsNil)
+	c.Assert(cmd.Start(), check.IsNil)
+
+	tr := tar.NewReader(stdout)
+	count := 0
+	for {
+		if _, err := tr.Next(); err == io.EOF {
+			break
+		} else if err != nil {
+			c.Fatal(err)
+		}
+		count++
+	}
+	c.Assert(cmd.Wait(), check.IsNil)
+	if count < 3 {
+		c.Fatalf("Expected at least 3 entries in the dump, got %d", count)
+	}
+}
+
+func (s *DockerSuite) TestStorageSelftest(c *check.C) {
+	testRequires(c, AufsDriver)
+	out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "storage", "selftest"))
+	// Checks may fail on the host, but every check is listed
+	for _, name := range []string{"CHECK", "aufs supported", "dirperm1", "xino writable", "rename on root", "whiteouts", "branch limit"} {
+		if !strings.Contains(out, name) {
+			c.Fatalf("Expected %s in the output, got %s: %v", name, out, err)
+		}
+	}
+	if err != nil && !strings.Contains(out, "failed") {
+		c.Fatalf("Expected a failed check with a non-zero exit status, got %s: %v", out, err)
+	}
+}
+
+func (s *DockerSuite) TestStorageUnsupported(c *check.C) {
+	testRequires(c, NotAufsDriver)
+	for _, args := range [][]string{{"storage", "selftest"}, {"storage", "dump", "-o", filepath.Join(c.MkDir(), "storage.tar")}} {
+		out, _, err := runCommandWithOutput(exec.Command(dockerBinary, args...))
+		if err == nil || !strings.Contains(out, "does not support") {
+			c.Fatalf("Expected docker %s to fail, got %s: %v", strings.Join(args, " "), out, err)
+		}
+	}
+}
+
+func (s *DockerSuite) TestStorageUnknownCommand(c *check.C) {
+	out, _, err := runCommandWithOutput(exec.Command(dockerBinary, "storage", "foo"))
+	if err == nil || !strings.Contains(out, "is not a docker storage command") {
+		c.Fatalf("Expected an unknown command error, got %s: %v", out, err)
+	}
+}
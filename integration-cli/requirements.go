@@ -24,7 +24,8 @@ type TestRequirement struct {
 
 // List test requirements
 var (
-	daemonExecDriver string
+	daemonExecDriver    string
+	daemonStorageDriver string
 
 	SameHostDaemon = TestRequirement{
 		func() bool { return isLocalDaemon },
@@ -100,6 +101,14 @@ var (
 		},
 		"Test requires the native (libcontainer) exec driver.",
 	}
+	AufsDriver = TestRequirement{
+		func() bool { return storageDriver() == "aufs" },
+		"Test requires the aufs storage driver.",
+	}
+	NotAufsDriver = TestRequirement{
+		func() bool { return storageDriver() != "aufs" },
+		"Test requires a storage driver other than aufs.",
+	}
 	NotOverlay = TestRequirement{
 		func() bool {
 			cmd := exec.Command("grep", "^overlay / overlay", "/proc/mounts")
@@ -141,6 +150,26 @@ var (
 	}
 )
 
+// storageDriver returns the storage driver of the tested daemon.
+func storageDriver() string {
+	if daemonStorageDriver == "" {
+		status, body, err := sockRequest("GET", "/info", nil)
+		if err != nil || status != http.StatusOK {
+			log.Fatalf("sockRequest failed for /info: %v", err)
+		}
+
+		var info struct {
+			Driver string
+		}
+		if err = json.Unmarshal(body, &info); err != nil {
+			log.Fatalf("unable to unmarshal body: %v", err)
+		}
+
+		daemonStorageDriver = info.Driver
+	}
+	return daemonStorageDriver
+}
+
 // testRequires checks if the environment satisfies the requirements
 // for the test to run or skips the tests.
 func testRequires(c *check.C, requirements ...TestRequirement) {
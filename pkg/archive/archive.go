@@ -34,6 +34,7 @@ type (
 		Compression     Compression
 		NoLchown        bool
 		Name            string
+		WhiteoutFormat  WhiteoutFormat
 	}
 
 	// Archiver allows the reuse of most utility functions of this package
@@ -447,6 +448,13 @@ func TarWithOptions(srcPath string, options *TarOptions) (io.ReadCloser, error)
 					return nil
 				}
 
+				if options.WhiteoutFormat == OCIWhiteoutFormat && isAUFSMetadata(f.Name()) {
+					if f.IsDir() {
+						return filepath.SkipDir
+					}
+					return nil
+				}
+
 				if seen[relFilePath] {
 					return nil
 				}
@@ -709,6 +709,45 @@ func TestTarWithOptions(t *testing.T) {
 	}
 }
 
+func TestTarWithOCIWhiteouts(t *testing.T) {
+	origin, err := ioutil.TempDir("", "docker-test-tar-whiteouts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(origin)
+	for _, p := range []string{"dir/" + WhiteoutOpaqueDir, "dir/" + WhiteoutPrefix + "file", "dir/" + WhiteoutLinkDir + "/link"} {
+		if err := os.MkdirAll(path.Dir(path.Join(origin, p)), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path.Join(origin, p), nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for format, expected := range map[WhiteoutFormat]int{AUFSWhiteoutFormat: 5, OCIWhiteoutFormat: 3} {
+		a, err := TarWithOptions(origin, &TarOptions{WhiteoutFormat: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		tr := tar.NewReader(a)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			names = append(names, hdr.Name)
+		}
+		a.Close()
+		if len(names) != expected {
+			t.Fatalf("Expected %d entries in format %d, got %v", expected, format, names)
+		}
+	}
+}
+
 // Some tar archives such as http://haproxy.1wt.eu/download/1.5/src/devel/haproxy-1.5-dev21.tar.gz
 // use PAX Global Extended Headers.
 // Failing prevents the archives from being uncompressed during ADD
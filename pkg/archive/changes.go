@@ -98,12 +98,31 @@ func Changes(layers []string, rw string) ([]Change, error) {
 			return err
 		}
 
+		// Find out what kind of modification happened
+		file := filepath.Base(path)
+
+		// An opaque directory hides everything the parent layers had in it,
+		// so whatever is not in rw was removed
+		if file == WhiteoutOpaqueDir {
+			dir := filepath.Dir(path)
+			deleted, err := opaqueDeletions(layers, rw, dir)
+			if err != nil {
+				return err
+			}
+			if _, ok := changedDirs[dir]; !ok && len(deleted) > 0 {
+				changes = append(changes, Change{Path: dir, Kind: ChangeModify})
+				changedDirs[dir] = struct{}{}
+			}
+			for _, p := range deleted {
+				changes = append(changes, Change{Path: p, Kind: ChangeDelete})
+			}
+			return nil
+		}
+
 		change := Change{
 			Path: path,
 		}
 
-		// Find out what kind of modification happened
-		file := filepath.Base(path)
 		// If there is a whiteout, then the file was removed
 		if strings.HasPrefix(file, ".wh.") {
 			originalFile := file[len(".wh."):]
@@ -116,7 +135,8 @@ func Changes(layers []string, rw string) ([]Change, error) {
 			// ...Unless it already existed in a top layer, in which case, it's a modification
 			for _, layer := range layers {
 				stat, err := os.Stat(filepath.Join(layer, path))
-				if err != nil && !os.IsNotExist(err) {
+				if err != nil && !os.IsNotExist(err) && !isNotDir(err) {
+					// A parent being a file in the layer hides path too
 					return err
 				}
 				if err == nil {
@@ -161,6 +181,93 @@ func Changes(layers []string, rw string) ([]Change, error) {
 	return changes, nil
 }
 
+// opaqueDeletions returns the entries of dir that are present in the
+// given parent layers but hidden by an opaque marker in rw. The parent
+// layers are read from the top down, until one of them hides dir from
+// the ones below: by a whiteout or an opaque marker of dir or of one of
+// its parents, or by holding a file in place of dir.
+func opaqueDeletions(layers []string, rw, dir string) ([]string, error) {
+	var (
+		deleted []string
+		seen    = make(map[string]struct{})
+	)
+	for _, layer := range layers {
+		fi, err := os.Lstat(filepath.Join(layer, dir))
+		if err != nil {
+			if isNotDir(err) {
+				// A parent of dir is a file in this layer
+				break
+			}
+			if !os.IsNotExist(err) {
+				return nil, err
+			}
+			if hidesBelow(layer, dir) {
+				break
+			}
+			continue
+		}
+		if !fi.IsDir() {
+			break
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(layer, dir))
+		if err != nil {
+			return nil, err
+		}
+		opaque := false
+		for _, fi := range entries {
+			name := fi.Name()
+			if name == WhiteoutOpaqueDir {
+				opaque = true
+				continue
+			}
+			if strings.HasPrefix(name, WhiteoutPrefix) {
+				// Already deleted further up the chain
+				seen[name[len(WhiteoutPrefix):]] = struct{}{}
+				continue
+			}
+			if _, ok := seen[name]; ok {
+				continue
+			}
+			seen[name] = struct{}{}
+			if _, err := os.Lstat(filepath.Join(rw, dir, name)); err == nil {
+				continue
+			} else if !os.IsNotExist(err) {
+				return nil, err
+			}
+			deleted = append(deleted, filepath.Join(dir, name))
+		}
+		if opaque || hidesBelow(layer, dir) {
+			break
+		}
+	}
+	return deleted, nil
+}
+
+// hidesBelow tells whether layer whites out dir or one of its parents, or
+// makes one of the parents of dir opaque, hiding dir in the layers below.
+func hidesBelow(layer, dir string) bool {
+	for d := dir; d != string(os.PathSeparator) && d != "."; d = filepath.Dir(d) {
+		parent := filepath.Join(layer, filepath.Dir(d))
+		if _, err := os.Lstat(filepath.Join(parent, WhiteoutPrefix+filepath.Base(d))); err == nil {
+			return true
+		}
+		if d == dir {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(layer, d, WhiteoutOpaqueDir)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+func isNotDir(err error) bool {
+	if pe, ok := err.(*os.PathError); ok {
+		err = pe.Err
+	}
+	return err == syscall.ENOTDIR
+}
+
 type FileInfo struct {
 	parent     *FileInfo
 	name       string
@@ -175,6 +175,83 @@ func TestChangesWithChanges(t *testing.T) {
 	checkChanges(expectedChanges, changes, t)
 }
 
+func TestChangesWithOpaqueDir(t *testing.T) {
+	// Mock the readonly layer
+	layer, err := ioutil.TempDir("", "docker-changes-test-layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(layer)
+	createSampleDir(t, layer)
+
+	// Mock the RW layer
+	rwLayer, err := ioutil.TempDir("", "docker-changes-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(rwLayer)
+
+	// Replace dir2 with a directory holding a single new file
+	dir2 := path.Join(rwLayer, "dir2")
+	os.MkdirAll(dir2, 0700)
+	ioutil.WriteFile(path.Join(dir2, WhiteoutOpaqueDir), []byte{}, 0600)
+	ioutil.WriteFile(path.Join(dir2, "newFile"), []byte{}, 0600)
+
+	changes, err := Changes([]string{layer}, rwLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedChanges := []Change{
+		{"/dir2", ChangeModify},
+		{"/dir2/file2-1", ChangeDelete},
+		{"/dir2/file2-2", ChangeDelete},
+		{"/dir2/newFile", ChangeAdd},
+	}
+	checkChanges(expectedChanges, changes, t)
+
+	// An upper parent layer whites out dir3, and replaces file1 with a
+	// directory the rw layer replaces in turn: neither dir3 nor file1 has
+	// entries of the lower layer to delete
+	upper, err := ioutil.TempDir("", "docker-changes-test-upper")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(upper)
+	ioutil.WriteFile(path.Join(upper, WhiteoutPrefix+"dir3"), []byte{}, 0600)
+	for _, dir := range []string{"dir3", "file1"} {
+		os.MkdirAll(path.Join(rwLayer, dir), 0700)
+		ioutil.WriteFile(path.Join(rwLayer, dir, WhiteoutOpaqueDir), []byte{}, 0600)
+	}
+	ioutil.WriteFile(path.Join(rwLayer, "file1", "newFile"), []byte{}, 0600)
+
+	changes, err = Changes([]string{upper, layer}, rwLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChanges = []Change{
+		{"/dir2", ChangeModify},
+		{"/dir2/file2-1", ChangeDelete},
+		{"/dir2/file2-2", ChangeDelete},
+		{"/dir2/newFile", ChangeAdd},
+		{"/dir3", ChangeModify},
+		{"/file1", ChangeModify},
+		{"/file1/newFile", ChangeAdd},
+	}
+	checkChanges(expectedChanges, changes, t)
+
+	// Without the upper layer, the content of dir3 is deleted
+	changes, err = Changes([]string{layer}, rwLayer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedChanges = append(expectedChanges[:5], append([]Change{
+		{"/dir3/file3-1", ChangeDelete},
+		{"/dir3/file3-2", ChangeDelete},
+	}, expectedChanges[5:]...)...)
+	checkChanges(expectedChanges, changes, t)
+}
+
 // See https://github.com/docker/docker/pull/13590
 func TestChangesWithChangesGH13590(t *testing.T) {
 	baseLayer, err := ioutil.TempDir("", "docker-changes-test.")
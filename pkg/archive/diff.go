@@ -25,6 +25,7 @@ func UnpackLayer(dest string, layer ArchiveReader) (size int64, err error) {
 
 	aufsTempdir := ""
 	aufsHardlinks := make(map[string]*tar.Header)
+	unpackedPaths := make(map[string]struct{})
 
 	// Iterate through the files in the archive.
 	for {
@@ -111,6 +112,17 @@ func UnpackLayer(dest string, layer ArchiveReader) (size int64, err error) {
 		}
 		base := filepath.Base(path)
 
+		if base == WhiteoutOpaqueDir {
+			// The directory was replaced in this layer: drop everything
+			// the lower layers put in it, but keep what this layer has
+			// already unpacked.
+			dir := filepath.Dir(path)
+			if err := removeUnpackedExcept(dir, unpackedPaths); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
 		if strings.HasPrefix(base, ".wh.") {
 			originalBase := base[len(".wh."):]
 			originalPath := filepath.Join(filepath.Dir(path), originalBase)
@@ -153,6 +165,7 @@ func UnpackLayer(dest string, layer ArchiveReader) (size int64, err error) {
 			if err := createTarFile(path, dest, srcHdr, srcData, true); err != nil {
 				return 0, err
 			}
+			unpackedPaths[path] = struct{}{}
 
 			// Directory mtimes must be handled at the end to avoid further
 			// file creation in them to modify the directory mtime
@@ -173,6 +186,29 @@ func UnpackLayer(dest string, layer ArchiveReader) (size int64, err error) {
 	return size, nil
 }
 
+// removeUnpackedExcept removes all the entries of dir except the ones
+// recorded in keep. It is used to give opaque directory markers their
+// "directory replaced" meaning on filesystems that don't support them.
+func removeUnpackedExcept(dir string, keep map[string]struct{}) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, fi := range entries {
+		p := filepath.Join(dir, fi.Name())
+		if _, ok := keep[p]; ok {
+			continue
+		}
+		if err := os.RemoveAll(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ApplyLayer parses a diff in the standard layer format from `layer`, and
 // applies it to the directory `dest`. Returns the size in bytes of the
 // contents of the layer.
@@ -2,6 +2,10 @@ package archive
 
 import (
 	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -188,3 +192,47 @@ func TestApplyLayerInvalidSymlink(t *testing.T) {
 		}
 	}
 }
+
+func TestApplyLayerOpaqueDir(t *testing.T) {
+	dest, err := ioutil.TempDir("", "docker-TestApplyLayerOpaqueDir")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dest)
+
+	if err := os.MkdirAll(filepath.Join(dest, "dir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dest, "dir", "old"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, hdr := range []*tar.Header{
+		{Name: "dir", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "dir/new", Typeflag: tar.TypeReg, Mode: 0644},
+		{Name: "dir/" + WhiteoutOpaqueDir, Typeflag: tar.TypeReg, Mode: 0644},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ApplyLayer(dest, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Lstat(filepath.Join(dest, "dir", "old")); !os.IsNotExist(err) {
+		t.Fatalf("expected dir/old to be removed by the opaque marker, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "dir", "new")); err != nil {
+		t.Fatalf("expected dir/new to be kept: %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "dir", WhiteoutOpaqueDir)); !os.IsNotExist(err) {
+		t.Fatalf("opaque marker should not be extracted, got %v", err)
+	}
+}
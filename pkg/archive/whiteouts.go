@@ -0,0 +1,45 @@
+package archive
+
+import "strings"
+
+// Whiteouts are files with a special meaning for the layered filesystem.
+// Docker uses AUFS whiteout files inside exported archives. In other
+// filesystems these files are generated/handled on tar creation/extraction.
+
+// WhiteoutPrefix prefix means file is a whiteout. If this is followed by a
+// filename this means that file has been removed from the base layer.
+const WhiteoutPrefix = ".wh."
+
+// WhiteoutMetaPrefix prefix means whiteout has a special meaning and is not
+// for removing an actual file. Normally these files are excluded from exported
+// archives.
+const WhiteoutMetaPrefix = WhiteoutPrefix + WhiteoutPrefix
+
+// WhiteoutLinkDir is a directory AUFS uses for storing hardlink links to other
+// layers. Normally these should not go into exported archives and all changed
+// hardlinks should be copied to the top layer.
+const WhiteoutLinkDir = WhiteoutMetaPrefix + "plnk"
+
+// WhiteoutOpaqueDir file means directory has been made opaque - meaning
+// readdir calls to this directory do not follow to lower layers. The same
+// name is used by the OCI image layer format.
+const WhiteoutOpaqueDir = WhiteoutMetaPrefix + ".opq"
+
+// WhiteoutFormat is the set of whiteout files an archive is made with.
+type WhiteoutFormat int
+
+const (
+	// AUFSWhiteoutFormat keeps the whiteout files as aufs stores them,
+	// including its metadata below the top of the tree.
+	AUFSWhiteoutFormat WhiteoutFormat = iota
+	// OCIWhiteoutFormat only keeps the whiteouts of the OCI image layer
+	// format: the ones of removed entries and the opaque directory
+	// markers of replaced directories.
+	OCIWhiteoutFormat
+)
+
+// isAUFSMetadata tells whether name is a whiteout file OCIWhiteoutFormat
+// leaves out.
+func isAUFSMetadata(name string) bool {
+	return strings.HasPrefix(name, WhiteoutMetaPrefix) && name != WhiteoutOpaqueDir
+}